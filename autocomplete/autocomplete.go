@@ -60,7 +60,7 @@ func (h *Handler) requestExpr(r *http.Request) (string, map[string]bool, error)
 		return "", usedTags, nil
 	}
 
-	where, err := finder.MakeTaggedWhere(expr)
+	where, err := finder.MakeTaggedWhere(expr, h.config.ClickHouse.TaggedSchema)
 	if err != nil {
 		return "", usedTags, err
 	}
@@ -101,24 +101,26 @@ func (h *Handler) ServeTags(w http.ResponseWriter, r *http.Request) {
 		where.And(exprWhere)
 	}
 
+	s := h.config.ClickHouse.TaggedSchema
+
 	var valueSQL string
 	if len(usedTags) == 0 {
-		valueSQL = "splitByChar('=', Tag1)[1] AS value"
+		valueSQL = fmt.Sprintf("splitByChar('=', %s)[1] AS value", s.Tag1)
 		if tagPrefix != "" {
-			where.Andf("Tag1 LIKE %s", finder.Q(tagPrefix+"%"))
+			where.Andf("%s LIKE %s", s.Tag1, finder.Q(tagPrefix+"%"))
 		}
 	} else {
-		valueSQL = "splitByChar('=', arrayJoin(Tags))[1] AS value"
+		valueSQL = fmt.Sprintf("splitByChar('=', arrayJoin(%s))[1] AS value", s.Tags)
 		if tagPrefix != "" {
-			where.Andf("arrayJoin(Tags) LIKE %s", finder.Q(tagPrefix+"%"))
+			where.Andf("arrayJoin(%s) LIKE %s", s.Tags, finder.Q(tagPrefix+"%"))
 		}
 	}
 
 	queryLimit := limit + len(usedTags)
 
 	fromDate := time.Now().AddDate(0, 0, -h.config.ClickHouse.TaggedAutocompleDays)
-	where.Andf("Date >= '%s'", fromDate.Format("2006-01-02"))
-	where.Andf("Deleted = 0")
+	where.And(s.DateFrom(fromDate))
+	where.Andf("%s = 0", s.Deleted)
 
 	sql := fmt.Sprintf("SELECT %s FROM %s %s GROUP BY value ORDER BY value LIMIT %d",
 		valueSQL,
@@ -210,18 +212,20 @@ func (h *Handler) ServeValues(w http.ResponseWriter, r *http.Request) {
 		where.And(exprWhere)
 	}
 
+	s := h.config.ClickHouse.TaggedSchema
+
 	var valueSQL string
 	if len(usedTags) == 0 {
-		valueSQL = "splitByChar('=', Tag1)[2] AS value"
-		where.Andf("Tag1 LIKE %s", finder.Q(tag+"="+valuePrefix+"%"))
+		valueSQL = fmt.Sprintf("splitByChar('=', %s)[2] AS value", s.Tag1)
+		where.Andf("%s LIKE %s", s.Tag1, finder.Q(tag+"="+valuePrefix+"%"))
 	} else {
-		valueSQL = "splitByChar('=', arrayJoin(Tags))[2] AS value"
-		where.Andf("arrayJoin(Tags) LIKE %s", finder.Q(tag+"="+valuePrefix+"%"))
+		valueSQL = fmt.Sprintf("splitByChar('=', arrayJoin(%s))[2] AS value", s.Tags)
+		where.Andf("arrayJoin(%s) LIKE %s", s.Tags, finder.Q(tag+"="+valuePrefix+"%"))
 	}
 
 	fromDate := time.Now().AddDate(0, 0, -h.config.ClickHouse.TaggedAutocompleDays)
-	where.Andf("Date >= '%s'", fromDate.Format("2006-01-02"))
-	where.Andf("Deleted = 0")
+	where.And(s.DateFrom(fromDate))
+	where.Andf("%s = 0", s.Deleted)
 
 	sql := fmt.Sprintf("SELECT %s FROM %s %s GROUP BY value ORDER BY value LIMIT %d",
 		valueSQL,
@@ -0,0 +1,2048 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: carbonapi_v3_pb.proto
+
+package carbonapi_v3_pb
+
+import (
+	encoding_binary "encoding/binary"
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+type FetchRequest struct {
+	Name                    string               `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	StartTime               int64                `protobuf:"varint,2,opt,name=startTime,proto3" json:"startTime,omitempty"`
+	StopTime                int64                `protobuf:"varint,3,opt,name=stopTime,proto3" json:"stopTime,omitempty"`
+	HighPrecisionTimestamps bool                 `protobuf:"varint,4,opt,name=highPrecisionTimestamps,proto3" json:"highPrecisionTimestamps,omitempty"`
+	PathExpression          string               `protobuf:"bytes,5,opt,name=pathExpression,proto3" json:"pathExpression,omitempty"`
+	FilterFunctions         []*FilteringFunction `protobuf:"bytes,6,rep,name=filterFunctions,proto3" json:"filterFunctions,omitempty"`
+	MaxDataPoints           int64                `protobuf:"varint,7,opt,name=maxDataPoints,proto3" json:"maxDataPoints,omitempty"`
+	XXX_NoUnkeyedLiteral    struct{}             `json:"-"`
+	XXX_unrecognized        []byte               `json:"-"`
+	XXX_sizecache           int32                `json:"-"`
+}
+
+func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
+func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
+func (*FetchRequest) ProtoMessage()    {}
+func (*FetchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_aa81c5198068fa1d, []int{0}
+}
+func (m *FetchRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *FetchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_FetchRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *FetchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchRequest.Merge(m, src)
+}
+func (m *FetchRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *FetchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchRequest proto.InternalMessageInfo
+
+func (m *FetchRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FetchRequest) GetStartTime() int64 {
+	if m != nil {
+		return m.StartTime
+	}
+	return 0
+}
+
+func (m *FetchRequest) GetStopTime() int64 {
+	if m != nil {
+		return m.StopTime
+	}
+	return 0
+}
+
+func (m *FetchRequest) GetHighPrecisionTimestamps() bool {
+	if m != nil {
+		return m.HighPrecisionTimestamps
+	}
+	return false
+}
+
+func (m *FetchRequest) GetPathExpression() string {
+	if m != nil {
+		return m.PathExpression
+	}
+	return ""
+}
+
+func (m *FetchRequest) GetFilterFunctions() []*FilteringFunction {
+	if m != nil {
+		return m.FilterFunctions
+	}
+	return nil
+}
+
+func (m *FetchRequest) GetMaxDataPoints() int64 {
+	if m != nil {
+		return m.MaxDataPoints
+	}
+	return 0
+}
+
+type MultiFetchRequest struct {
+	Metrics              []*FetchRequest `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}        `json:"-"`
+	XXX_unrecognized     []byte          `json:"-"`
+	XXX_sizecache        int32           `json:"-"`
+}
+
+func (m *MultiFetchRequest) Reset()         { *m = MultiFetchRequest{} }
+func (m *MultiFetchRequest) String() string { return proto.CompactTextString(m) }
+func (*MultiFetchRequest) ProtoMessage()    {}
+func (*MultiFetchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_aa81c5198068fa1d, []int{1}
+}
+func (m *MultiFetchRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MultiFetchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MultiFetchRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MultiFetchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MultiFetchRequest.Merge(m, src)
+}
+func (m *MultiFetchRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *MultiFetchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MultiFetchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MultiFetchRequest proto.InternalMessageInfo
+
+func (m *MultiFetchRequest) GetMetrics() []*FetchRequest {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
+type FetchResponse struct {
+	Name                    string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	PathExpression          string            `protobuf:"bytes,2,opt,name=pathExpression,proto3" json:"pathExpression,omitempty"`
+	ConsolidationFunc       string            `protobuf:"bytes,3,opt,name=consolidationFunc,proto3" json:"consolidationFunc,omitempty"`
+	StartTime               int64             `protobuf:"varint,4,opt,name=startTime,proto3" json:"startTime,omitempty"`
+	StopTime                int64             `protobuf:"varint,5,opt,name=stopTime,proto3" json:"stopTime,omitempty"`
+	StepTime                int64             `protobuf:"varint,6,opt,name=stepTime,proto3" json:"stepTime,omitempty"`
+	XFilesFactor            float32           `protobuf:"fixed32,7,opt,name=xFilesFactor,proto3" json:"xFilesFactor,omitempty"`
+	HighPrecisionTimestamps bool              `protobuf:"varint,8,opt,name=highPrecisionTimestamps,proto3" json:"highPrecisionTimestamps,omitempty"`
+	Values                  []float64         `protobuf:"fixed64,9,rep,packed,name=values,proto3" json:"values,omitempty"`
+	Tags                    map[string]string `protobuf:"bytes,10,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	AppliedFunctions        []string          `protobuf:"bytes,11,rep,name=appliedFunctions,proto3" json:"appliedFunctions,omitempty"`
+	RequestStartTime        int64             `protobuf:"varint,12,opt,name=requestStartTime,proto3" json:"requestStartTime,omitempty"`
+	RequestStopTime         int64             `protobuf:"varint,13,opt,name=requestStopTime,proto3" json:"requestStopTime,omitempty"`
+	XXX_NoUnkeyedLiteral    struct{}          `json:"-"`
+	XXX_unrecognized        []byte            `json:"-"`
+	XXX_sizecache           int32             `json:"-"`
+}
+
+func (m *FetchResponse) Reset()         { *m = FetchResponse{} }
+func (m *FetchResponse) String() string { return proto.CompactTextString(m) }
+func (*FetchResponse) ProtoMessage()    {}
+func (*FetchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_aa81c5198068fa1d, []int{2}
+}
+func (m *FetchResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *FetchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_FetchResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *FetchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FetchResponse.Merge(m, src)
+}
+func (m *FetchResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *FetchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_FetchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FetchResponse proto.InternalMessageInfo
+
+func (m *FetchResponse) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FetchResponse) GetPathExpression() string {
+	if m != nil {
+		return m.PathExpression
+	}
+	return ""
+}
+
+func (m *FetchResponse) GetConsolidationFunc() string {
+	if m != nil {
+		return m.ConsolidationFunc
+	}
+	return ""
+}
+
+func (m *FetchResponse) GetStartTime() int64 {
+	if m != nil {
+		return m.StartTime
+	}
+	return 0
+}
+
+func (m *FetchResponse) GetStopTime() int64 {
+	if m != nil {
+		return m.StopTime
+	}
+	return 0
+}
+
+func (m *FetchResponse) GetStepTime() int64 {
+	if m != nil {
+		return m.StepTime
+	}
+	return 0
+}
+
+func (m *FetchResponse) GetXFilesFactor() float32 {
+	if m != nil {
+		return m.XFilesFactor
+	}
+	return 0
+}
+
+func (m *FetchResponse) GetHighPrecisionTimestamps() bool {
+	if m != nil {
+		return m.HighPrecisionTimestamps
+	}
+	return false
+}
+
+func (m *FetchResponse) GetValues() []float64 {
+	if m != nil {
+		return m.Values
+	}
+	return nil
+}
+
+func (m *FetchResponse) GetTags() map[string]string {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+func (m *FetchResponse) GetAppliedFunctions() []string {
+	if m != nil {
+		return m.AppliedFunctions
+	}
+	return nil
+}
+
+func (m *FetchResponse) GetRequestStartTime() int64 {
+	if m != nil {
+		return m.RequestStartTime
+	}
+	return 0
+}
+
+func (m *FetchResponse) GetRequestStopTime() int64 {
+	if m != nil {
+		return m.RequestStopTime
+	}
+	return 0
+}
+
+type MultiFetchResponse struct {
+	Metrics              []*FetchResponse `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *MultiFetchResponse) Reset()         { *m = MultiFetchResponse{} }
+func (m *MultiFetchResponse) String() string { return proto.CompactTextString(m) }
+func (*MultiFetchResponse) ProtoMessage()    {}
+func (*MultiFetchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_aa81c5198068fa1d, []int{3}
+}
+func (m *MultiFetchResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MultiFetchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MultiFetchResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MultiFetchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MultiFetchResponse.Merge(m, src)
+}
+func (m *MultiFetchResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *MultiFetchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MultiFetchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MultiFetchResponse proto.InternalMessageInfo
+
+func (m *MultiFetchResponse) GetMetrics() []*FetchResponse {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
+type FilteringFunction struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Arguments            []string `protobuf:"bytes,2,rep,name=arguments,proto3" json:"arguments,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FilteringFunction) Reset()         { *m = FilteringFunction{} }
+func (m *FilteringFunction) String() string { return proto.CompactTextString(m) }
+func (*FilteringFunction) ProtoMessage()    {}
+func (*FilteringFunction) Descriptor() ([]byte, []int) {
+	return fileDescriptor_aa81c5198068fa1d, []int{4}
+}
+func (m *FilteringFunction) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *FilteringFunction) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_FilteringFunction.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *FilteringFunction) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FilteringFunction.Merge(m, src)
+}
+func (m *FilteringFunction) XXX_Size() int {
+	return m.Size()
+}
+func (m *FilteringFunction) XXX_DiscardUnknown() {
+	xxx_messageInfo_FilteringFunction.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FilteringFunction proto.InternalMessageInfo
+
+func (m *FilteringFunction) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FilteringFunction) GetArguments() []string {
+	if m != nil {
+		return m.Arguments
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*FetchRequest)(nil), "carbonapi_v3_pb.FetchRequest")
+	proto.RegisterType((*MultiFetchRequest)(nil), "carbonapi_v3_pb.MultiFetchRequest")
+	proto.RegisterType((*FetchResponse)(nil), "carbonapi_v3_pb.FetchResponse")
+	proto.RegisterMapType((map[string]string)(nil), "carbonapi_v3_pb.FetchResponse.TagsEntry")
+	proto.RegisterType((*MultiFetchResponse)(nil), "carbonapi_v3_pb.MultiFetchResponse")
+	proto.RegisterType((*FilteringFunction)(nil), "carbonapi_v3_pb.FilteringFunction")
+}
+
+func init() { proto.RegisterFile("carbonapi_v3_pb.proto", fileDescriptor_aa81c5198068fa1d) }
+
+var fileDescriptor_aa81c5198068fa1d = []byte{
+	// 513 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x54, 0x5d, 0x6f, 0xd3, 0x30,
+	0x14, 0x95, 0x93, 0xae, 0x6b, 0xee, 0x5a, 0xba, 0x5a, 0x7c, 0x44, 0x13, 0x54, 0x51, 0x84, 0x50,
+	0x84, 0x50, 0x1f, 0xd8, 0xc3, 0x2a, 0xc4, 0x13, 0xa2, 0x7d, 0x1a, 0x68, 0x32, 0x7b, 0x9f, 0xdc,
+	0xcc, 0xb4, 0x16, 0x89, 0x6d, 0x6c, 0x77, 0xea, 0xfe, 0x16, 0x3f, 0x80, 0x67, 0x1e, 0xf9, 0x09,
+	0xa8, 0xbf, 0x04, 0xc5, 0x4d, 0xdb, 0x35, 0x59, 0xfb, 0xe6, 0x7b, 0xce, 0xb5, 0x74, 0xef, 0x39,
+	0xc7, 0x86, 0x67, 0x29, 0xd5, 0x13, 0x29, 0xa8, 0xe2, 0x37, 0x77, 0xe7, 0x37, 0x6a, 0x32, 0x50,
+	0x5a, 0x5a, 0x89, 0xbb, 0x15, 0x38, 0xfe, 0xe5, 0x41, 0x7b, 0xcc, 0x6c, 0x3a, 0x23, 0xec, 0xe7,
+	0x9c, 0x19, 0x8b, 0x31, 0x34, 0x04, 0xcd, 0x59, 0x88, 0x22, 0x94, 0x04, 0xc4, 0x9d, 0xf1, 0x4b,
+	0x08, 0x8c, 0xa5, 0xda, 0x5e, 0xf3, 0x9c, 0x85, 0x5e, 0x84, 0x12, 0x9f, 0x6c, 0x01, 0x7c, 0x06,
+	0x2d, 0x63, 0xa5, 0x72, 0xa4, 0xef, 0xc8, 0x4d, 0x8d, 0x87, 0xf0, 0x62, 0xc6, 0xa7, 0xb3, 0x2b,
+	0xcd, 0x52, 0x6e, 0xb8, 0x14, 0x05, 0x68, 0x2c, 0xcd, 0x95, 0x09, 0x1b, 0x11, 0x4a, 0x5a, 0x64,
+	0x1f, 0x8d, 0xdf, 0xc0, 0x13, 0x45, 0xed, 0x6c, 0xb4, 0x50, 0x9a, 0x99, 0x82, 0x0b, 0x8f, 0xdc,
+	0x44, 0x15, 0x14, 0x5f, 0x42, 0xf7, 0x3b, 0xcf, 0x2c, 0xd3, 0xe3, 0xb9, 0x48, 0x2d, 0x97, 0xc2,
+	0x84, 0xcd, 0xc8, 0x4f, 0x4e, 0xde, 0xc7, 0x83, 0xaa, 0x04, 0x63, 0xd7, 0xc7, 0xc5, 0x74, 0xdd,
+	0x4a, 0xaa, 0x57, 0xf1, 0x6b, 0xe8, 0xe4, 0x74, 0xf1, 0x99, 0x5a, 0x7a, 0x25, 0xb9, 0xb0, 0x26,
+	0x3c, 0x76, 0x0b, 0xed, 0x82, 0xf1, 0x25, 0xf4, 0xbe, 0xcc, 0x33, 0xcb, 0x77, 0x84, 0xbb, 0x80,
+	0xe3, 0x9c, 0x59, 0xcd, 0x53, 0x13, 0x22, 0x37, 0xc0, 0xab, 0xfa, 0x00, 0x0f, 0xfa, 0xc9, 0xba,
+	0x3b, 0xfe, 0xdd, 0x80, 0x4e, 0xc9, 0x18, 0x25, 0x85, 0x61, 0x8f, 0x7a, 0x50, 0xd7, 0xc3, 0x7b,
+	0x54, 0x8f, 0x77, 0xd0, 0x4b, 0xa5, 0x30, 0x32, 0xe3, 0xb7, 0xb4, 0xd8, 0xa9, 0xd8, 0xcd, 0xd9,
+	0x12, 0x90, 0x3a, 0xb1, 0xeb, 0x6c, 0xe3, 0x90, 0xb3, 0x47, 0x15, 0x67, 0x1d, 0xc7, 0x56, 0x5c,
+	0x73, 0xcd, 0xad, 0x6a, 0x1c, 0x43, 0x7b, 0x31, 0xe6, 0x19, 0x33, 0x63, 0x9a, 0x5a, 0xa9, 0x9d,
+	0x88, 0x1e, 0xd9, 0xc1, 0x0e, 0x25, 0xa3, 0x75, 0x38, 0x19, 0xcf, 0xa1, 0x79, 0x47, 0xb3, 0x39,
+	0x33, 0x61, 0x10, 0xf9, 0x09, 0x22, 0x65, 0x85, 0x3f, 0x42, 0xc3, 0xd2, 0xa9, 0x09, 0xc1, 0xa9,
+	0x9f, 0xec, 0x53, 0x7f, 0xa5, 0xf1, 0xe0, 0x9a, 0x4e, 0xcd, 0x48, 0x58, 0x7d, 0x4f, 0xdc, 0x2d,
+	0xfc, 0x16, 0x4e, 0xa9, 0x52, 0x19, 0x67, 0xb7, 0xdb, 0x20, 0x9d, 0x44, 0x7e, 0x12, 0x90, 0x1a,
+	0x5e, 0xf4, 0xea, 0x95, 0x8b, 0xdf, 0x36, 0xe2, 0xb5, 0x9d, 0x06, 0x35, 0x1c, 0x27, 0xd0, 0xdd,
+	0x60, 0xa5, 0x94, 0x1d, 0xd7, 0x5a, 0x85, 0xcf, 0x2e, 0x20, 0xd8, 0x0c, 0x85, 0x4f, 0xc1, 0xff,
+	0xc1, 0xee, 0xcb, 0x04, 0x14, 0x47, 0xfc, 0x14, 0x8e, 0xdc, 0xa2, 0xa5, 0xef, 0xab, 0xe2, 0x83,
+	0x37, 0x44, 0xf1, 0x57, 0xc0, 0x0f, 0xe3, 0x58, 0x86, 0x68, 0x58, 0xcd, 0x63, 0xff, 0xb0, 0x22,
+	0xdb, 0x40, 0x8e, 0xa0, 0x57, 0x7b, 0x2a, 0xfb, 0xfe, 0x05, 0xaa, 0xa7, 0xf3, 0x9c, 0x15, 0x2f,
+	0xc5, 0x73, 0x62, 0x6d, 0x81, 0x4f, 0xed, 0x3f, 0xcb, 0x3e, 0xfa, 0xbb, 0xec, 0xa3, 0x7f, 0xcb,
+	0x3e, 0x9a, 0x34, 0xdd, 0x07, 0x74, 0xfe, 0x7f, 0x00, 0x0b, 0x8a, 0x85, 0x23, 0x99, 0x04, 0x00,
+	0x00,
+}
+
+func (m *FetchRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *FetchRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *FetchRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.MaxDataPoints != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.MaxDataPoints))
+		i--
+		dAtA[i] = 0x38
+	}
+	if len(m.FilterFunctions) > 0 {
+		for iNdEx := len(m.FilterFunctions) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.FilterFunctions[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if len(m.PathExpression) > 0 {
+		i -= len(m.PathExpression)
+		copy(dAtA[i:], m.PathExpression)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.PathExpression)))
+		i--
+		dAtA[i] = 0x2a
+	}
+	if m.HighPrecisionTimestamps {
+		i--
+		if m.HighPrecisionTimestamps {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x20
+	}
+	if m.StopTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.StopTime))
+		i--
+		dAtA[i] = 0x18
+	}
+	if m.StartTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.StartTime))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *MultiFetchRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MultiFetchRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *MultiFetchRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Metrics) > 0 {
+		for iNdEx := len(m.Metrics) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Metrics[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *FetchResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *FetchResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *FetchResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.RequestStopTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.RequestStopTime))
+		i--
+		dAtA[i] = 0x68
+	}
+	if m.RequestStartTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.RequestStartTime))
+		i--
+		dAtA[i] = 0x60
+	}
+	if len(m.AppliedFunctions) > 0 {
+		for iNdEx := len(m.AppliedFunctions) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.AppliedFunctions[iNdEx])
+			copy(dAtA[i:], m.AppliedFunctions[iNdEx])
+			i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.AppliedFunctions[iNdEx])))
+			i--
+			dAtA[i] = 0x5a
+		}
+	}
+	if len(m.Tags) > 0 {
+		for k := range m.Tags {
+			v := m.Tags[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(v)))
+			i--
+			dAtA[i] = 0x12
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x52
+		}
+	}
+	if len(m.Values) > 0 {
+		for iNdEx := len(m.Values) - 1; iNdEx >= 0; iNdEx-- {
+			f1 := math.Float64bits(float64(m.Values[iNdEx]))
+			i -= 8
+			encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(f1))
+		}
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.Values)*8))
+		i--
+		dAtA[i] = 0x4a
+	}
+	if m.HighPrecisionTimestamps {
+		i--
+		if m.HighPrecisionTimestamps {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.XFilesFactor != 0 {
+		i -= 4
+		encoding_binary.LittleEndian.PutUint32(dAtA[i:], uint32(math.Float32bits(float32(m.XFilesFactor))))
+		i--
+		dAtA[i] = 0x3d
+	}
+	if m.StepTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.StepTime))
+		i--
+		dAtA[i] = 0x30
+	}
+	if m.StopTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.StopTime))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.StartTime != 0 {
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(m.StartTime))
+		i--
+		dAtA[i] = 0x20
+	}
+	if len(m.ConsolidationFunc) > 0 {
+		i -= len(m.ConsolidationFunc)
+		copy(dAtA[i:], m.ConsolidationFunc)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.ConsolidationFunc)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if len(m.PathExpression) > 0 {
+		i -= len(m.PathExpression)
+		copy(dAtA[i:], m.PathExpression)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.PathExpression)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *MultiFetchResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MultiFetchResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *MultiFetchResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Metrics) > 0 {
+		for iNdEx := len(m.Metrics) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Metrics[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *FilteringFunction) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *FilteringFunction) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *FilteringFunction) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Arguments) > 0 {
+		for iNdEx := len(m.Arguments) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Arguments[iNdEx])
+			copy(dAtA[i:], m.Arguments[iNdEx])
+			i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.Arguments[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintCarbonapiV3Pb(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintCarbonapiV3Pb(dAtA []byte, offset int, v uint64) int {
+	offset -= sovCarbonapiV3Pb(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *FetchRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	if m.StartTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.StartTime))
+	}
+	if m.StopTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.StopTime))
+	}
+	if m.HighPrecisionTimestamps {
+		n += 2
+	}
+	l = len(m.PathExpression)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	if len(m.FilterFunctions) > 0 {
+		for _, e := range m.FilterFunctions {
+			l = e.Size()
+			n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+		}
+	}
+	if m.MaxDataPoints != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.MaxDataPoints))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *MultiFetchRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Metrics) > 0 {
+		for _, e := range m.Metrics {
+			l = e.Size()
+			n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *FetchResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	l = len(m.PathExpression)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	l = len(m.ConsolidationFunc)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	if m.StartTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.StartTime))
+	}
+	if m.StopTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.StopTime))
+	}
+	if m.StepTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.StepTime))
+	}
+	if m.XFilesFactor != 0 {
+		n += 5
+	}
+	if m.HighPrecisionTimestamps {
+		n += 2
+	}
+	if len(m.Values) > 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(len(m.Values)*8)) + len(m.Values)*8
+	}
+	if len(m.Tags) > 0 {
+		for k, v := range m.Tags {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovCarbonapiV3Pb(uint64(len(k))) + 1 + len(v) + sovCarbonapiV3Pb(uint64(len(v)))
+			n += mapEntrySize + 1 + sovCarbonapiV3Pb(uint64(mapEntrySize))
+		}
+	}
+	if len(m.AppliedFunctions) > 0 {
+		for _, s := range m.AppliedFunctions {
+			l = len(s)
+			n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+		}
+	}
+	if m.RequestStartTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.RequestStartTime))
+	}
+	if m.RequestStopTime != 0 {
+		n += 1 + sovCarbonapiV3Pb(uint64(m.RequestStopTime))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *MultiFetchResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Metrics) > 0 {
+		for _, e := range m.Metrics {
+			l = e.Size()
+			n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func (m *FilteringFunction) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+	}
+	if len(m.Arguments) > 0 {
+		for _, s := range m.Arguments {
+			l = len(s)
+			n += 1 + l + sovCarbonapiV3Pb(uint64(l))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
+func sovCarbonapiV3Pb(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozCarbonapiV3Pb(x uint64) (n int) {
+	return sovCarbonapiV3Pb(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *FetchRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: FetchRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: FetchRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartTime", wireType)
+			}
+			m.StartTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StartTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StopTime", wireType)
+			}
+			m.StopTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StopTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HighPrecisionTimestamps", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HighPrecisionTimestamps = bool(v != 0)
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PathExpression", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PathExpression = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FilterFunctions", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.FilterFunctions = append(m.FilterFunctions, &FilteringFunction{})
+			if err := m.FilterFunctions[len(m.FilterFunctions)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxDataPoints", wireType)
+			}
+			m.MaxDataPoints = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxDataPoints |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MultiFetchRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MultiFetchRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MultiFetchRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metrics", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metrics = append(m.Metrics, &FetchRequest{})
+			if err := m.Metrics[len(m.Metrics)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *FetchResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: FetchResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: FetchResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PathExpression", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.PathExpression = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ConsolidationFunc", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.ConsolidationFunc = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StartTime", wireType)
+			}
+			m.StartTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StartTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StopTime", wireType)
+			}
+			m.StopTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StopTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StepTime", wireType)
+			}
+			m.StepTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.StepTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 7:
+			if wireType != 5 {
+				return fmt.Errorf("proto: wrong wireType = %d for field XFilesFactor", wireType)
+			}
+			var v uint32
+			if (iNdEx + 4) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint32(encoding_binary.LittleEndian.Uint32(dAtA[iNdEx:]))
+			iNdEx += 4
+			m.XFilesFactor = float32(math.Float32frombits(v))
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HighPrecisionTimestamps", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.HighPrecisionTimestamps = bool(v != 0)
+		case 9:
+			if wireType == 1 {
+				var v uint64
+				if (iNdEx + 8) > l {
+					return io.ErrUnexpectedEOF
+				}
+				v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+				iNdEx += 8
+				v2 := float64(math.Float64frombits(v))
+				m.Values = append(m.Values, v2)
+			} else if wireType == 2 {
+				var packedLen int
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowCarbonapiV3Pb
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					packedLen |= int(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				if packedLen < 0 {
+					return ErrInvalidLengthCarbonapiV3Pb
+				}
+				postIndex := iNdEx + packedLen
+				if postIndex < 0 {
+					return ErrInvalidLengthCarbonapiV3Pb
+				}
+				if postIndex > l {
+					return io.ErrUnexpectedEOF
+				}
+				var elementCount int
+				elementCount = packedLen / 8
+				if elementCount != 0 && len(m.Values) == 0 {
+					m.Values = make([]float64, 0, elementCount)
+				}
+				for iNdEx < postIndex {
+					var v uint64
+					if (iNdEx + 8) > l {
+						return io.ErrUnexpectedEOF
+					}
+					v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+					iNdEx += 8
+					v2 := float64(math.Float64frombits(v))
+					m.Values = append(m.Values, v2)
+				}
+			} else {
+				return fmt.Errorf("proto: wrong wireType = %d for field Values", wireType)
+			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Tags", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Tags == nil {
+				m.Tags = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowCarbonapiV3Pb
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowCarbonapiV3Pb
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthCarbonapiV3Pb
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthCarbonapiV3Pb
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowCarbonapiV3Pb
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthCarbonapiV3Pb
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthCarbonapiV3Pb
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthCarbonapiV3Pb
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Tags[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field AppliedFunctions", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.AppliedFunctions = append(m.AppliedFunctions, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RequestStartTime", wireType)
+			}
+			m.RequestStartTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RequestStartTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RequestStopTime", wireType)
+			}
+			m.RequestStopTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.RequestStopTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MultiFetchResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MultiFetchResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MultiFetchResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metrics", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metrics = append(m.Metrics, &FetchResponse{})
+			if err := m.Metrics[len(m.Metrics)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *FilteringFunction) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: FilteringFunction: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: FilteringFunction: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Arguments", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Arguments = append(m.Arguments, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbonapiV3Pb(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbonapiV3Pb
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipCarbonapiV3Pb(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	depth := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowCarbonapiV3Pb
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+		case 1:
+			iNdEx += 8
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowCarbonapiV3Pb
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthCarbonapiV3Pb
+			}
+			iNdEx += length
+		case 3:
+			depth++
+		case 4:
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupCarbonapiV3Pb
+			}
+			depth--
+		case 5:
+			iNdEx += 4
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthCarbonapiV3Pb
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+var (
+	ErrInvalidLengthCarbonapiV3Pb        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowCarbonapiV3Pb          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupCarbonapiV3Pb = fmt.Errorf("proto: unexpected end of group")
+)
@@ -0,0 +1,3 @@
+package carbonapi_v3_pb
+
+//go:generate protoc --gogofast_out=. carbonapi_v3_pb.proto
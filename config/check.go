@@ -0,0 +1,79 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
+)
+
+// Modes of tables check at startup
+const (
+	CheckTablesOff  = "off"  // tables are not checked
+	CheckTablesWarn = "warn" // problems are logged
+	CheckTablesFail = "fail" // startup fails on problems
+)
+
+// CheckTables describes every configured table and returns readable problems
+// with columns expected by finders and render
+func (cfg *Config) CheckTables(ctx context.Context) []string {
+	problems := make([]string, 0)
+
+	check := func(name string, url string, table string, opts clickhouse.Options, columns []schema.Column) {
+		if table == "" {
+			return
+		}
+
+		described, err := schema.Describe(ctx, url, table, opts)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", name, table, err.Error()))
+			return
+		}
+
+		for _, p := range schema.CheckColumns(described, columns) {
+			problems = append(problems, fmt.Sprintf("%s %s: %s", name, table, p))
+		}
+	}
+
+	ch := &cfg.ClickHouse
+
+	treeOpts := clickhouse.Options{
+		Timeout:        ch.TreeTimeout.Value(),
+		ConnectTimeout: ch.ConnectTimeout.Value(),
+	}
+
+	dataOpts := clickhouse.Options{
+		Timeout:        ch.DataTimeout.Value(),
+		ConnectTimeout: ch.ConnectTimeout.Value(),
+	}
+
+	check("data-table", ch.Url, ch.DataTable, dataOpts, ch.DataSchema.DataColumns())
+	check("tree-table", ch.Url, ch.TreeTable, treeOpts, ch.TreeSchema.TreeColumns())
+	check("date-tree-table", ch.Url, ch.DateTreeTable, treeOpts, ch.TreeSchema.DateTreeColumns(ch.DateTreeTableVersion))
+	check("reverse-tree-table", ch.Url, ch.ReverseTreeTable, treeOpts, ch.TreeSchema.TreeColumns())
+	// tag table has fixed columns of carbon-clickhouse
+	check("tag-table", ch.Url, ch.TagTable, treeOpts, schema.Default().TagColumns())
+	check("tagged-table", ch.Url, ch.TaggedTable, treeOpts, ch.TaggedSchema.TaggedColumns())
+
+	for i := 0; i < len(cfg.DataTable); i++ {
+		t := &cfg.DataTable[i]
+
+		url := ch.Url
+		if t.Url != "" {
+			url = t.Url
+		}
+
+		opts := dataOpts
+		if t.DataTimeout != nil {
+			opts.Timeout = t.DataTimeout.Value()
+		}
+		if t.ConnectTimeout != nil {
+			opts.ConnectTimeout = t.ConnectTimeout.Value()
+		}
+
+		check(fmt.Sprintf("[[data-table]] #%d", i+1), url, t.Table, opts, t.Schema.DataColumns())
+	}
+
+	return problems
+}
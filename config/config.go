@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"regexp"
@@ -10,10 +11,16 @@ import (
 
 	"github.com/BurntSushi/toml"
 
+	"github.com/lomik/graphite-clickhouse/helper/cache"
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
 	"github.com/lomik/graphite-clickhouse/helper/rollup"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 	"github.com/lomik/zapwriter"
 )
 
+// RollupAuto is value of rollup-conf for rules from system.graphite_retentions of clickhouse
+const RollupAuto = "auto"
+
 // Duration wrapper time.Duration for TOML
 type Duration struct {
 	time.Duration
@@ -46,23 +53,52 @@ type Common struct {
 	MaxCPU          int              `toml:"max-cpu"`
 	TargetBlacklist []string         `toml:"target-blacklist"`
 	Blacklist       []*regexp.Regexp `toml:"-"` // compiled TargetBlacklist
+	CheckTables     string           `toml:"check-tables"`
 }
 
 type ClickHouse struct {
-	Url                  string    `toml:"url"`
-	DataTable            string    `toml:"data-table"`
-	DataTimeout          *Duration `toml:"data-timeout"`
-	TreeTable            string    `toml:"tree-table"`
-	DateTreeTable        string    `toml:"date-tree-table"`
-	DateTreeTableVersion int       `toml:"date-tree-table-version"`
-	TaggedTable          string    `toml:"tagged-table"`
-	TaggedAutocompleDays int       `toml:"tagged-autocomplete-days"`
-	ReverseTreeTable     string    `toml:"reverse-tree-table"`
-	TreeTimeout          *Duration `toml:"tree-timeout"`
-	TagTable             string    `toml:"tag-table"`
-	RollupConf           string    `toml:"rollup-conf"`
-	ExtraPrefix          string    `toml:"extra-prefix"`
-	ConnectTimeout       *Duration `toml:"connect-timeout"`
+	Url                  string         `toml:"url"`
+	Replicas             []string       `toml:"replicas"`
+	Balance              string         `toml:"balance"`
+	ReplicaEjectTime     *Duration      `toml:"replica-eject-time"`
+	HealthCheckInterval  *Duration      `toml:"health-check-interval"`
+	HedgeDelay           *Duration      `toml:"hedge-delay"`
+	HedgePercentile      float64        `toml:"hedge-percentile"`
+	DataTable            string         `toml:"data-table"`
+	DataTimeout          *Duration      `toml:"data-timeout"`
+	TreeTable            string         `toml:"tree-table"`
+	DateTreeTable        string         `toml:"date-tree-table"`
+	DateTreeTableVersion int            `toml:"date-tree-table-version"`
+	TaggedTable          string         `toml:"tagged-table"`
+	TaggedAutocompleDays int            `toml:"tagged-autocomplete-days"`
+	ReverseTreeTable     string         `toml:"reverse-tree-table"`
+	TreeTimeout          *Duration      `toml:"tree-timeout"`
+	TagTable             string         `toml:"tag-table"`
+	RollupConf           string         `toml:"rollup-conf"`
+	RollupAutoTable      string         `toml:"rollup-auto-table"`
+	RollupAutoInterval   *Duration      `toml:"rollup-auto-interval"`
+	RollupStorageSchemas string         `toml:"rollup-storage-schemas"`
+	RollupStorageAggr    string         `toml:"rollup-storage-aggregation"`
+	ExtraPrefix          string         `toml:"extra-prefix"`
+	ConnectTimeout       *Duration      `toml:"connect-timeout"`
+	MaxIdleConnsPerHost  int            `toml:"max-idle-conns-per-host"`
+	IdleConnTimeout      *Duration      `toml:"idle-conn-timeout"`
+	TCPKeepAlive         *Duration      `toml:"tcp-keep-alive"`
+	InternalAggregation  bool           `toml:"internal-aggregation"`
+	DataChunkSize        int            `toml:"data-chunk-size"`
+	DataMaxConcurrent    int            `toml:"data-max-concurrent"`
+	StitchDataTables     bool           `toml:"stitch-data-tables"`
+	DataSchema           *schema.Schema `toml:"data-schema"`
+	TreeSchema           *schema.Schema `toml:"tree-schema"`
+	TaggedSchema         *schema.Schema `toml:"tagged-schema"`
+}
+
+// Limits of single request. 0 - no limit
+type Limits struct {
+	MaxSeriesPerTarget int   `toml:"max-series-per-target"`
+	MaxSeries          int   `toml:"max-series"`
+	MaxPoints          int64 `toml:"max-points"`
+	MaxFindResults     int   `toml:"max-find-results"`
 }
 
 type Tags struct {
@@ -82,29 +118,47 @@ type Carbonlink struct {
 }
 
 type DataTable struct {
-	Table                string         `toml:"table"`
-	Reverse              bool           `toml:"reverse"`
-	MaxAge               *Duration      `toml:"max-age"`
-	MinAge               *Duration      `toml:"min-age"`
-	MaxInterval          *Duration      `toml:"max-interval"`
-	MinInterval          *Duration      `toml:"min-interval"`
-	TargetMatchAny       string         `toml:"target-match-any"`
-	TargetMatchAll       string         `toml:"target-match-all"`
-	TargetMatchAnyRegexp *regexp.Regexp `toml:"-"`
-	TargetMatchAllRegexp *regexp.Regexp `toml:"-"`
-	RollupConf           string         `toml:"rollup-conf"`
-	Rollup               *rollup.Rollup `toml:"-"`
+	Table                string            `toml:"table"`
+	Url                  string            `toml:"url"`
+	Replicas             []string          `toml:"replicas"`
+	DataTimeout          *Duration         `toml:"data-timeout"`
+	ConnectTimeout       *Duration         `toml:"connect-timeout"`
+	Settings             map[string]string `toml:"settings"`
+	Schema               *schema.Schema    `toml:"schema"`
+	Reverse              bool              `toml:"reverse"`
+	MaxAge               *Duration         `toml:"max-age"`
+	MinAge               *Duration         `toml:"min-age"`
+	MaxInterval          *Duration         `toml:"max-interval"`
+	MinInterval          *Duration         `toml:"min-interval"`
+	TargetMatchAny       string            `toml:"target-match-any"`
+	TargetMatchAll       string            `toml:"target-match-all"`
+	TargetMatchAnyRegexp *regexp.Regexp    `toml:"-"`
+	TargetMatchAllRegexp *regexp.Regexp    `toml:"-"`
+	RollupConf           string            `toml:"rollup-conf"`
+	RollupAutoTable      string            `toml:"rollup-auto-table"`
+	RollupStorageSchemas string            `toml:"rollup-storage-schemas"`
+	RollupStorageAggr    string            `toml:"rollup-storage-aggregation"`
+	Rollup               *rollup.Rollup    `toml:"-"`
+}
+
+type Cache struct {
+	Size  int          `toml:"size-mb"` // 0 - cache is disabled
+	TTL   *Duration    `toml:"ttl"`
+	Cache *cache.Cache `toml:"-"`
 }
 
 // Config ...
 type Config struct {
-	Common     Common             `toml:"common"`
-	ClickHouse ClickHouse         `toml:"clickhouse"`
-	DataTable  []DataTable        `toml:"data-table"`
-	Tags       Tags               `toml:"tags"`
-	Carbonlink Carbonlink         `toml:"carbonlink"`
-	Logging    []zapwriter.Config `toml:"logging"`
-	Rollup     *rollup.Rollup     `toml:"-"`
+	Common      Common             `toml:"common"`
+	ClickHouse  ClickHouse         `toml:"clickhouse"`
+	DataTable   []DataTable        `toml:"data-table"`
+	Tags        Tags               `toml:"tags"`
+	Carbonlink  Carbonlink         `toml:"carbonlink"`
+	Limits      Limits             `toml:"limits"`
+	FindCache   Cache              `toml:"find-cache"`
+	RenderCache Cache              `toml:"render-cache"`
+	Logging     []zapwriter.Config `toml:"logging"`
+	Rollup      *rollup.Rollup     `toml:"-"`
 }
 
 // NewConfig ...
@@ -117,10 +171,15 @@ func New() *Config {
 			// 	Duration: time.Minute,
 			// },
 			// MetricEndpoint: MetricEndpointLocal,
-			MaxCPU: 1,
+			MaxCPU:      1,
+			CheckTables: CheckTablesOff,
 		},
 		ClickHouse: ClickHouse{
-			Url: "http://localhost:8123",
+			Url:                 "http://localhost:8123",
+			Balance:             clickhouse.BalanceRoundRobin,
+			ReplicaEjectTime:    &Duration{Duration: 10 * time.Second},
+			HealthCheckInterval: &Duration{Duration: 5 * time.Second},
+			HedgeDelay:          &Duration{},
 
 			DataTable: "graphite",
 			DataTimeout: &Duration{
@@ -131,9 +190,17 @@ func New() *Config {
 				Duration: time.Minute,
 			},
 			RollupConf:           "/etc/graphite-clickhouse/rollup.xml",
+			RollupAutoInterval:   &Duration{Duration: time.Minute},
 			TagTable:             "",
 			TaggedAutocompleDays: 7,
 			ConnectTimeout:       &Duration{Duration: time.Second},
+			MaxIdleConnsPerHost:  clickhouse.DefaultTransportOptions.MaxIdleConnsPerHost,
+			IdleConnTimeout:      &Duration{Duration: clickhouse.DefaultTransportOptions.IdleConnTimeout},
+			TCPKeepAlive:         &Duration{Duration: clickhouse.DefaultTransportOptions.KeepAlive},
+			DataMaxConcurrent:    4,
+			DataSchema:           schema.Default(),
+			TreeSchema:           schema.Default(),
+			TaggedSchema:         schema.Default(),
 		},
 		Tags: Tags{
 			Date:  "2016-11-01",
@@ -146,6 +213,12 @@ func New() *Config {
 			QueryTimeout:   &Duration{Duration: 50 * time.Millisecond},
 			TotalTimeout:   &Duration{Duration: 500 * time.Millisecond},
 		},
+		FindCache: Cache{
+			TTL: &Duration{Duration: time.Minute},
+		},
+		RenderCache: Cache{
+			TTL: &Duration{Duration: 30 * time.Second},
+		},
 		Logging: nil,
 	}
 
@@ -215,18 +288,78 @@ func ReadConfig(filename string) (*Config, error) {
 		return nil, err
 	}
 
-	rollupConfBody, err := ioutil.ReadFile(cfg.ClickHouse.RollupConf)
+	switch cfg.Common.CheckTables {
+	case CheckTablesOff, CheckTablesWarn, CheckTablesFail:
+	default:
+		return nil, fmt.Errorf("unknown check-tables mode %#v", cfg.Common.CheckTables)
+	}
+
+	if cfg.ClickHouse.MaxIdleConnsPerHost < 0 {
+		return nil, fmt.Errorf("max-idle-conns-per-host should be >= 0")
+	}
+
+	clickhouse.SetTransportOptions(clickhouse.TransportOptions{
+		MaxIdleConnsPerHost: cfg.ClickHouse.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.ClickHouse.IdleConnTimeout.Value(),
+		KeepAlive:           cfg.ClickHouse.TCPKeepAlive.Value(),
+	})
+
+	replicas := make(map[string][]string)
+	if len(cfg.ClickHouse.Replicas) > 0 {
+		replicas[cfg.ClickHouse.Url] = cfg.ClickHouse.Replicas
+	}
+	for i := 0; i < len(cfg.DataTable); i++ {
+		t := &cfg.DataTable[i]
+		if len(t.Replicas) == 0 {
+			continue
+		}
+		if t.Url == "" {
+			return nil, fmt.Errorf("replicas of data-table %#v require url", t.Table)
+		}
+		if _, exists := replicas[t.Url]; exists && strings.Join(replicas[t.Url], ",") != strings.Join(t.Replicas, ",") {
+			return nil, fmt.Errorf("different replicas of url %#v", t.Url)
+		}
+		replicas[t.Url] = t.Replicas
+	}
+
+	// health checks of replicas are started with the server by clickhouse.StartHealthChecks
+	err = clickhouse.SetReplicas(replicas, clickhouse.ClusterOptions{
+		Balance:             cfg.ClickHouse.Balance,
+		EjectTime:           cfg.ClickHouse.ReplicaEjectTime.Value(),
+		HealthCheckInterval: cfg.ClickHouse.HealthCheckInterval.Value(),
+		HedgeDelay:          cfg.ClickHouse.HedgeDelay.Value(),
+		HedgePercentile:     cfg.ClickHouse.HedgePercentile,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := rollup.ParseXML(rollupConfBody)
+	autoTable := cfg.ClickHouse.RollupAutoTable
+	if autoTable == "" {
+		autoTable = cfg.ClickHouse.DataTable
+	}
+
+	r, err := cfg.newRollup(
+		cfg.ClickHouse.Url,
+		cfg.ClickHouse.RollupConf,
+		cfg.ClickHouse.RollupStorageSchemas,
+		cfg.ClickHouse.RollupStorageAggr,
+		autoTable,
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	cfg.Rollup = r
 
+	if cfg.FindCache.Size > 0 {
+		cfg.FindCache.Cache = cache.New(int64(cfg.FindCache.Size) * 1024 * 1024)
+	}
+
+	if cfg.RenderCache.Size > 0 {
+		cfg.RenderCache.Cache = cache.New(int64(cfg.RenderCache.Size) * 1024 * 1024)
+	}
+
 	l := len(cfg.Common.TargetBlacklist)
 	if l > 0 {
 		cfg.Common.Blacklist = make([]*regexp.Regexp, l)
@@ -239,7 +372,17 @@ func ReadConfig(filename string) (*Config, error) {
 		}
 	}
 
+	for _, s := range []*schema.Schema{cfg.ClickHouse.DataSchema, cfg.ClickHouse.TreeSchema, cfg.ClickHouse.TaggedSchema} {
+		s.SetDefaults()
+	}
+
 	for i := 0; i < len(cfg.DataTable); i++ {
+		if cfg.DataTable[i].Schema == nil {
+			cfg.DataTable[i].Schema = cfg.ClickHouse.DataSchema
+		} else {
+			cfg.DataTable[i].Schema.SetDefaults()
+		}
+
 		if cfg.DataTable[i].TargetMatchAny != "" {
 			r, err := regexp.Compile(cfg.DataTable[i].TargetMatchAny)
 			if err != nil {
@@ -255,13 +398,24 @@ func ReadConfig(filename string) (*Config, error) {
 			cfg.DataTable[i].TargetMatchAllRegexp = r
 		}
 
-		if cfg.DataTable[i].RollupConf != "" {
-			rollupConfBody, err := ioutil.ReadFile(cfg.DataTable[i].RollupConf)
-			if err != nil {
-				return nil, err
+		if cfg.DataTable[i].RollupConf != "" || cfg.DataTable[i].RollupStorageSchemas != "" {
+			autoTable := cfg.DataTable[i].RollupAutoTable
+			if autoTable == "" {
+				autoTable = cfg.DataTable[i].Table
 			}
 
-			r, err := rollup.ParseXML(rollupConfBody)
+			url := cfg.DataTable[i].Url
+			if url == "" {
+				url = cfg.ClickHouse.Url
+			}
+
+			r, err := cfg.newRollup(
+				url,
+				cfg.DataTable[i].RollupConf,
+				cfg.DataTable[i].RollupStorageSchemas,
+				cfg.DataTable[i].RollupStorageAggr,
+				autoTable,
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -272,3 +426,61 @@ func ReadConfig(filename string) (*Config, error) {
 
 	return cfg, nil
 }
+
+// StartRollups loads rules of "auto" rollups from clickhouse and reloads them in background until ctx is done
+func (cfg *Config) StartRollups(ctx context.Context) error {
+	if err := cfg.Rollup.Start(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(cfg.DataTable); i++ {
+		if cfg.DataTable[i].Rollup == nil {
+			continue
+		}
+		if err := cfg.DataTable[i].Rollup.Start(ctx); err != nil {
+			return fmt.Errorf("[[data-table]] %s: %s", cfg.DataTable[i].Table, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// newRollup reads rollup rules from carbon storageSchemas and storageAggregation files if storageSchemas is set,
+// from system.graphite_retentions of autoTable in clickhouse url if rollupConf is "auto" or from xml file rollupConf
+func (cfg *Config) newRollup(url, rollupConf, storageSchemas, storageAggregation, autoTable string) (*rollup.Rollup, error) {
+	if storageSchemas != "" {
+		schemasBody, err := ioutil.ReadFile(storageSchemas)
+		if err != nil {
+			return nil, err
+		}
+
+		var aggregationBody []byte
+		if storageAggregation != "" {
+			aggregationBody, err = ioutil.ReadFile(storageAggregation)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return rollup.ParseCarbon(schemasBody, aggregationBody)
+	}
+
+	if rollupConf == RollupAuto {
+		return rollup.NewAuto(
+			url,
+			autoTable,
+			cfg.ClickHouse.RollupAutoInterval.Value(),
+			clickhouse.Options{
+				Timeout:        cfg.ClickHouse.TreeTimeout.Value(),
+				ConnectTimeout: cfg.ClickHouse.ConnectTimeout.Value(),
+			},
+		), nil
+	}
+
+	rollupConfBody, err := ioutil.ReadFile(rollupConf)
+	if err != nil {
+		return nil, err
+	}
+
+	return rollup.ParseXML(rollupConfBody)
+}
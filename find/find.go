@@ -19,8 +19,10 @@ type Find struct {
 	result  finder.Result
 }
 
-func New(config *config.Config, ctx context.Context, query string) (*Find, error) {
-	res, err := finder.Find(config, ctx, query, 0, 0)
+func New(config *config.Config, ctx context.Context, query string, useCache bool) (*Find, error) {
+	limit := finder.Limit{Name: "max-find-results", Max: int64(config.Limits.MaxFindResults)}
+
+	res, err := finder.Find(config, ctx, query, 0, 0, limit, useCache)
 	if err != nil {
 		return nil, err
 	}
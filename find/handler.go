@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/helper/cache"
 )
 
 type Handler struct {
@@ -19,7 +20,7 @@ func NewHandler(config *config.Config) *Handler {
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(1024 * 1024)
 
-	f, err := New(h.config, r.Context(), r.FormValue("query"))
+	f, err := New(h.config, r.Context(), r.FormValue("query"), !cache.NoCache(r))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
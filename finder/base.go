@@ -7,20 +7,25 @@ import (
 	"strings"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type BaseFinder struct {
-	url   string             // clickhouse dsn
-	table string             // graphite_tree table
-	opts  clickhouse.Options // timeout, connectTimeout
-	body  []byte             // clickhouse response body
+	url    string             // clickhouse dsn
+	table  string             // graphite_tree table
+	schema *schema.Schema     // column names of table
+	opts   clickhouse.Options // timeout, connectTimeout
+	limit  int                // max rows of response, 0 - unlimited
+	body   []byte             // clickhouse response body
 }
 
-func NewBase(url string, table string, opts clickhouse.Options) Finder {
+func NewBase(url string, table string, s *schema.Schema, opts clickhouse.Options, limit int) Finder {
 	return &BaseFinder{
-		url:   url,
-		table: table,
-		opts:  opts,
+		url:    url,
+		table:  table,
+		schema: s,
+		opts:   opts,
+		limit:  limit,
 	}
 }
 
@@ -29,7 +34,7 @@ func (b *BaseFinder) where(query string) string {
 
 	w := NewWhere()
 
-	w.Andf("Level = %d", level)
+	w.Andf("%s = %d", b.schema.Level, level)
 
 	if query == "*" {
 		return w.String()
@@ -37,7 +42,7 @@ func (b *BaseFinder) where(query string) string {
 
 	// simple metric
 	if !HasWildcard(query) {
-		w.Andf("Path = %s OR Path = %s", Q(query), Q(query+"."))
+		w.Andf("%s = %s OR %s = %s", b.schema.Path, Q(query), b.schema.Path, Q(query+"."))
 		return w.String()
 	}
 
@@ -45,7 +50,7 @@ func (b *BaseFinder) where(query string) string {
 	simplePrefix := query[:strings.IndexAny(query, "[]{}*?")]
 
 	if len(simplePrefix) > 0 {
-		w.Andf("Path LIKE %s", Q(simplePrefix+`%`))
+		w.Andf("%s LIKE %s", b.schema.Path, Q(simplePrefix+`%`))
 	}
 
 	// prefix search like "metric.name.xx*"
@@ -55,7 +60,7 @@ func (b *BaseFinder) where(query string) string {
 
 	// Q() replaces \ with \\, so using \. does not work here.
 	// work around with [.]
-	w.Andf("match(Path, %s)", Q(`^`+GlobToRegexp(query)+`[.]?$`))
+	w.Andf("match(%s, %s)", b.schema.Path, Q(`^`+GlobToRegexp(query)+`[.]?$`))
 	return w.String()
 }
 
@@ -65,7 +70,10 @@ func (b *BaseFinder) Execute(ctx context.Context, query string, from int64, unti
 	b.body, err = clickhouse.Query(
 		ctx,
 		b.url,
-		fmt.Sprintf("SELECT Path FROM %s WHERE %s GROUP BY Path HAVING argMax(Deleted, Version)==0", b.table, where),
+		fmt.Sprintf(
+			"SELECT %s FROM %s WHERE %s GROUP BY %s HAVING %s%s",
+			b.schema.Path, b.table, where, b.schema.Path, b.schema.NotDeleted(), limitSQL(b.limit),
+		),
 		b.table,
 		b.opts,
 	)
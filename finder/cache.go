@@ -0,0 +1,160 @@
+package finder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+var errCacheBroken = errors.New("broken find cache value")
+
+// CachedResult is find result restored from cache
+type CachedResult struct {
+	list   [][]byte
+	series [][]byte
+	abs    map[string][]byte
+}
+
+func (c *CachedResult) List() [][]byte {
+	return c.list
+}
+
+func (c *CachedResult) Series() [][]byte {
+	return c.series
+}
+
+func (c *CachedResult) Abs(v []byte) []byte {
+	if abs, ok := c.abs[string(v)]; ok {
+		return abs
+	}
+	return v
+}
+
+// findCacheKey returns cache key of query. Date range is a part of key for date and tagged finders
+func findCacheKey(query string, from int64, until int64) string {
+	if from > 0 && until > 0 {
+		return query + ";" + time.Unix(from, 0).Format("2006-01-02") + ";" + time.Unix(until, 0).Format("2006-01-02")
+	}
+	return query
+}
+
+// marshalResult encodes list, series and absolute names of series as sequence of length prefixed byte strings
+func marshalResult(r Result) []byte {
+	buf := new(bytes.Buffer)
+	var n [binary.MaxVarintLen64]byte
+
+	writeBytes := func(v []byte) {
+		buf.Write(n[:binary.PutUvarint(n[:], uint64(len(v)))])
+		buf.Write(v)
+	}
+
+	list := r.List()
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(list)))])
+	for _, v := range list {
+		writeBytes(v)
+	}
+
+	series := r.Series()
+	buf.Write(n[:binary.PutUvarint(n[:], uint64(len(series)))])
+	for _, v := range series {
+		writeBytes(v)
+		writeBytes(r.Abs(v))
+	}
+
+	return buf.Bytes()
+}
+
+func unmarshalResult(body []byte) (*CachedResult, error) {
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(body)
+		if n <= 0 {
+			return 0, errCacheBroken
+		}
+		body = body[n:]
+		return v, nil
+	}
+
+	readBytes := func() ([]byte, error) {
+		l, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(body)) < l {
+			return nil, errCacheBroken
+		}
+		v := body[:l]
+		body = body[l:]
+		return v, nil
+	}
+
+	count, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &CachedResult{
+		list: make([][]byte, 0, count),
+		abs:  make(map[string][]byte),
+	}
+
+	for i := uint64(0); i < count; i++ {
+		v, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		res.list = append(res.list, v)
+	}
+
+	if count, err = readUvarint(); err != nil {
+		return nil, err
+	}
+
+	res.series = make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		v, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		abs, err := readBytes()
+		if err != nil {
+			return nil, err
+		}
+		res.series = append(res.series, v)
+		if !bytes.Equal(v, abs) {
+			res.abs[string(v)] = abs
+		}
+	}
+
+	return res, nil
+}
+
+// cachedFind returns result from find cache or executes find and stores result to cache
+func cachedFind(config *config.Config, ctx context.Context, query string, from int64, until int64, limit Limit) (Result, error) {
+	c := config.FindCache.Cache
+	key := findCacheKey(query, from, until)
+
+	if body, ok := c.Get(key); ok {
+		res, err := unmarshalResult(body)
+		if err == nil {
+			c.Log(ctx, "find", true)
+			// result may be cached by request with greater limit
+			if err = CheckLimit(ctx, limit.Name, int64(len(res.List())), limit.Max); err != nil {
+				return nil, err
+			}
+			return res, nil
+		}
+	}
+	c.Log(ctx, "find", false)
+
+	res, err := find(config, ctx, query, from, until, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Set(key, marshalResult(res), config.FindCache.TTL.Value())
+	return res, nil
+}
@@ -0,0 +1,35 @@
+package finder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedResult(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMockFinder([][]byte{[]byte("world"), []byte("folder.")})
+	f := WrapPrefix(m, "hello")
+	assert.NoError(f.Execute(context.Background(), "hello.*", 0, 0))
+
+	res, err := unmarshalResult(marshalResult(f))
+	assert.NoError(err)
+
+	assert.Equal(f.List(), res.List())
+	assert.Equal(f.Series(), res.Series())
+	for _, s := range f.Series() {
+		assert.Equal(string(f.Abs(s)), string(res.Abs(s)))
+	}
+
+	_, err = unmarshalResult([]byte{5, 1})
+	assert.Error(err)
+}
+
+func TestFindCacheKey(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("a.*", findCacheKey("a.*", 0, 0))
+	assert.Equal("a.*;2018-03-03;2018-03-04", findCacheKey("a.*", 1520056680, 1520156680))
+}
@@ -3,9 +3,9 @@ package finder
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type DateFinder struct {
@@ -13,15 +13,17 @@ type DateFinder struct {
 	tableVersion int
 }
 
-func NewDateFinder(url string, table string, tableVersion int, opts clickhouse.Options) Finder {
+func NewDateFinder(url string, table string, tableVersion int, s *schema.Schema, opts clickhouse.Options, limit int) Finder {
 	if tableVersion == 3 {
-		return NewDateFinderV3(url, table, opts)
+		return NewDateFinderV3(url, table, s, opts, limit)
 	}
 
 	b := &BaseFinder{
-		url:   url,
-		table: table,
-		opts:  opts,
+		url:    url,
+		table:  table,
+		schema: s,
+		opts:   opts,
+		limit:  limit,
 	}
 
 	return &DateFinder{b, tableVersion}
@@ -30,20 +32,22 @@ func NewDateFinder(url string, table string, tableVersion int, opts clickhouse.O
 func (b *DateFinder) Execute(ctx context.Context, query string, from int64, until int64) (err error) {
 	where := b.where(query)
 
+	// table without Date column is queried without PREWHERE
 	dateWhere := NewWhere()
-	dateWhere.Andf(
-		"Date >='%s' AND Date <= '%s'",
-		time.Unix(from, 0).Format("2006-01-02"),
-		time.Unix(until, 0).Format("2006-01-02"),
-	)
+	dateWhere.And(b.schema.DateWhere(from, until))
+
+	preWhere := ""
+	if dateWhere.String() != "" {
+		preWhere = fmt.Sprintf("PREWHERE (%s)", dateWhere)
+	}
 
 	if b.tableVersion == 2 {
 		b.body, err = clickhouse.Query(
 			ctx,
 			b.url,
 			fmt.Sprintf(
-				`SELECT Path FROM %s PREWHERE (%s) WHERE (%s) GROUP BY Path HAVING argMax(Deleted, Version)==0`,
-				b.table, dateWhere.String(), where),
+				`SELECT %s FROM %s %s WHERE (%s) GROUP BY %s HAVING %s%s`,
+				b.schema.Path, b.table, preWhere, where, b.schema.Path, b.schema.NotDeleted(), limitSQL(b.limit)),
 			b.table,
 			b.opts,
 		)
@@ -51,7 +55,7 @@ func (b *DateFinder) Execute(ctx context.Context, query string, from int64, unti
 		b.body, err = clickhouse.Query(
 			ctx,
 			b.url,
-			fmt.Sprintf(`SELECT DISTINCT Path FROM %s PREWHERE (%s) WHERE (%s)`, b.table, dateWhere.String(), where),
+			fmt.Sprintf(`SELECT DISTINCT %s FROM %s %s WHERE (%s)%s`, b.schema.Path, b.table, preWhere, where, limitSQL(b.limit)),
 			b.table,
 			b.opts,
 		)
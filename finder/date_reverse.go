@@ -3,9 +3,9 @@ package finder
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type DateFinderV3 struct {
@@ -13,11 +13,13 @@ type DateFinderV3 struct {
 }
 
 // Same as v2, but reversed
-func NewDateFinderV3(url string, table string, opts clickhouse.Options) Finder {
+func NewDateFinderV3(url string, table string, s *schema.Schema, opts clickhouse.Options, limit int) Finder {
 	b := &BaseFinder{
-		url:   url,
-		table: table,
-		opts:  opts,
+		url:    url,
+		table:  table,
+		schema: s,
+		opts:   opts,
+		limit:  limit,
 	}
 
 	return &DateFinderV3{b}
@@ -26,19 +28,16 @@ func NewDateFinderV3(url string, table string, opts clickhouse.Options) Finder {
 func (f *DateFinderV3) Execute(ctx context.Context, query string, from int64, until int64) (err error) {
 	where := f.where(ReverseString(query))
 
-	dateWhere := NewWhere()
-	dateWhere.Andf(
-		"Date >='%s' AND Date <= '%s'",
-		time.Unix(from, 0).Format("2006-01-02"),
-		time.Unix(until, 0).Format("2006-01-02"),
-	)
+	w := NewWhere()
+	w.And(f.schema.DateWhere(from, until))
+	w.And(where)
 
 	f.body, err = clickhouse.Query(
 		ctx,
 		f.url,
 		fmt.Sprintf(
-			`SELECT Path FROM %s WHERE (%s) AND (%s) GROUP BY Path HAVING argMax(Deleted, Version)==0`,
-			f.table, dateWhere.String(), where),
+			`SELECT %s FROM %s WHERE %s GROUP BY %s HAVING %s%s`,
+			f.schema.Path, f.table, w, f.schema.Path, f.schema.NotDeleted(), limitSQL(f.limit)),
 		f.table,
 		f.opts,
 	)
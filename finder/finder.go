@@ -20,7 +20,17 @@ type Finder interface {
 	Execute(ctx context.Context, query string, from int64, until int64) error
 }
 
-func Find(config *config.Config, ctx context.Context, query string, from int64, until int64) (Result, error) {
+// Find executes query with finder selected by config. Results are taken from find cache
+// if it is enabled and useCache is true. At most limit.Max+1 rows are fetched from clickhouse,
+// LimitError is returned if there are more than limit.Max results
+func Find(config *config.Config, ctx context.Context, query string, from int64, until int64, limit Limit, useCache bool) (Result, error) {
+	if useCache && config.FindCache.Cache != nil {
+		return cachedFind(config, ctx, query, from, until, limit)
+	}
+	return find(config, ctx, query, from, until, limit)
+}
+
+func find(config *config.Config, ctx context.Context, query string, from int64, until int64, limit Limit) (Result, error) {
 	opts := clickhouse.Options{
 		Timeout:        config.ClickHouse.TreeTimeout.Value(),
 		ConnectTimeout: config.ClickHouse.ConnectTimeout.Value(),
@@ -30,47 +40,47 @@ func Find(config *config.Config, ctx context.Context, query string, from int64,
 		var f Finder
 
 		if config.ClickHouse.TaggedTable != "" && strings.HasPrefix(strings.TrimSpace(query), "seriesByTag") {
-			f = NewTagged(config.ClickHouse.Url, config.ClickHouse.TaggedTable, opts)
-
-			if len(config.Common.Blacklist) > 0 {
-				f = WrapBlacklist(f, config.Common.Blacklist)
-			}
-
-			return f
+			return NewTagged(config.ClickHouse.Url, config.ClickHouse.TaggedTable, config.ClickHouse.TaggedSchema, opts, limit.rows())
 		}
 
 		if from > 0 && until > 0 && config.ClickHouse.DateTreeTable != "" {
-			f = NewDateFinder(config.ClickHouse.Url, config.ClickHouse.DateTreeTable, config.ClickHouse.DateTreeTableVersion, opts)
+			f = NewDateFinder(config.ClickHouse.Url, config.ClickHouse.DateTreeTable, config.ClickHouse.DateTreeTableVersion, config.ClickHouse.TreeSchema, opts, limit.rows())
 		} else {
-			f = NewBase(config.ClickHouse.Url, config.ClickHouse.TreeTable, opts)
+			f = NewBase(config.ClickHouse.Url, config.ClickHouse.TreeTable, config.ClickHouse.TreeSchema, opts, limit.rows())
 		}
 
 		if config.ClickHouse.ReverseTreeTable != "" {
-			f = WrapReverse(f, config.ClickHouse.Url, config.ClickHouse.ReverseTreeTable, opts)
+			f = WrapReverse(f, config.ClickHouse.Url, config.ClickHouse.ReverseTreeTable, config.ClickHouse.TreeSchema, opts, limit.rows())
 		}
 
 		if config.ClickHouse.TagTable != "" {
-			f = WrapTag(f, config.ClickHouse.Url, config.ClickHouse.TagTable, opts)
+			f = WrapTag(f, config.ClickHouse.Url, config.ClickHouse.TagTable, opts, limit.rows())
 		}
 
 		if config.ClickHouse.ExtraPrefix != "" {
 			f = WrapPrefix(f, config.ClickHouse.ExtraPrefix)
 		}
 
-		if len(config.Common.Blacklist) > 0 {
-			f = WrapBlacklist(f, config.Common.Blacklist)
-		}
-
 		return f
 
 	}()
 
-	err := fnd.Execute(ctx, query, from, until)
+	result := fnd
+	if len(config.Common.Blacklist) > 0 {
+		result = WrapBlacklist(fnd, config.Common.Blacklist)
+	}
+
+	err := result.Execute(ctx, query, from, until)
 	if err != nil {
 		return nil, err
 	}
 
-	return fnd.(Result), err
+	// response may be truncated by LIMIT, so results are counted before blacklist filter
+	if err = CheckLimit(ctx, limit.Name, int64(len(fnd.List())), limit.Max); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
 // Leaf strips last dot and detect IsLeaf
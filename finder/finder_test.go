@@ -0,0 +1,48 @@
+package finder
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/helper/cache"
+)
+
+func TestFindLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		queries = append(queries, string(body))
+		w.Write([]byte("a.b\na.c\na.d\n"))
+	}))
+	defer srv.Close()
+
+	cfg := config.New()
+	cfg.ClickHouse.Url = srv.URL
+	cfg.FindCache.Cache = cache.New(1024 * 1024)
+
+	res, err := Find(cfg, context.Background(), "a.*", 0, 0, Limit{Name: "max-find-results", Max: 3}, true)
+	assert.NoError(err)
+	assert.Len(res.List(), 3)
+	assert.Contains(queries[0], " LIMIT 4")
+
+	// result is taken from cache, but exceeds smaller limit
+	_, err = Find(cfg, context.Background(), "a.*", 0, 0, Limit{Name: "max-find-results", Max: 2}, true)
+	assert.Equal(&LimitError{Limit: "max-find-results", Value: 3, Max: 2}, err)
+	assert.Len(queries, 1)
+
+	_, err = Find(cfg, context.Background(), "a.*", 0, 0, Limit{Name: "max-find-results", Max: 2}, false)
+	assert.Equal(&LimitError{Limit: "max-find-results", Value: 3, Max: 2}, err)
+	assert.Contains(queries[1], " LIMIT 3")
+
+	_, err = Find(cfg, context.Background(), "a.*", 0, 0, Limit{}, false)
+	assert.NoError(err)
+	assert.NotContains(queries[2], "LIMIT")
+}
@@ -0,0 +1,61 @@
+package finder
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/graphite-clickhouse/helper/log"
+)
+
+// Limit is named limit of find results. Max <= 0 means no limit
+type Limit struct {
+	Name string
+	Max  int64
+}
+
+// rows returns max count of rows to fetch from clickhouse: one row more than limit
+// is enough to find out that limit is exceeded. 0 - unlimited
+func (l Limit) rows() int {
+	if l.Max <= 0 {
+		return 0
+	}
+	return int(l.Max) + 1
+}
+
+// limitSQL returns LIMIT clause of query for at most rows rows. Empty if rows is 0
+func limitSQL(rows int) string {
+	if rows <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", rows)
+}
+
+// LimitError is returned if request exceeds one of [limits] of config
+type LimitError struct {
+	Limit string // name of limit
+	Value int64
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// CheckLimit returns LimitError if value is greater than max. max <= 0 means no limit.
+// Exceeded limits are logged with request logger
+func CheckLimit(ctx context.Context, limit string, value int64, max int64) error {
+	if max <= 0 || value <= max {
+		return nil
+	}
+
+	err := &LimitError{Limit: limit, Value: value, Max: max}
+	log.FromContext(ctx).Warn("limit",
+		zap.String("limit", limit),
+		zap.Int64("value", value),
+		zap.Int64("max", max),
+	)
+
+	return err
+}
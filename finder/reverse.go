@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type ReverseFinder struct {
@@ -38,10 +39,10 @@ func ReverseBytes(target []byte) []byte {
 	return bytes.Join(a, []byte{'.'})
 }
 
-func WrapReverse(f Finder, url string, table string, opts clickhouse.Options) *ReverseFinder {
+func WrapReverse(f Finder, url string, table string, s *schema.Schema, opts clickhouse.Options, limit int) *ReverseFinder {
 	return &ReverseFinder{
 		wrapped:    f,
-		baseFinder: NewBase(url, table, opts),
+		baseFinder: NewBase(url, table, s, opts, limit),
 		url:        url,
 		table:      table,
 	}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type TagState int
@@ -58,6 +59,7 @@ type TagFinder struct {
 	url         string             // clickhouse dsn
 	table       string             // graphite_tag table
 	opts        clickhouse.Options // clickhouse timeout, connectTimeout, etc
+	limit       int                // max rows of response, 0 - unlimited
 	state       TagState
 	tagQuery    []TagQ
 	seriesQuery string
@@ -67,12 +69,13 @@ type TagFinder struct {
 
 var EmptyList [][]byte = [][]byte{}
 
-func WrapTag(f Finder, url string, table string, opts clickhouse.Options) *TagFinder {
+func WrapTag(f Finder, url string, table string, opts clickhouse.Options, limit int) *TagFinder {
 	return &TagFinder{
 		wrapped:  f,
 		url:      url,
 		table:    table,
 		opts:     opts,
+		limit:    limit,
 		tagQuery: make([]TagQ, 0),
 	}
 }
@@ -129,7 +132,8 @@ func (t *TagFinder) seriesSQL() (string, error) {
 		}
 	}
 
-	base := &BaseFinder{}
+	// tag table has fixed columns of carbon-clickhouse
+	base := &BaseFinder{schema: schema.Default()}
 	w.And(base.where(t.seriesQuery))
 
 	return fmt.Sprintf("SELECT Path FROM %s WHERE %s GROUP BY Path", t.table, w), nil
@@ -219,7 +223,7 @@ func (t *TagFinder) Execute(ctx context.Context, query string, from int64, until
 	}
 
 	if sql != "" {
-		t.body, err = clickhouse.Query(ctx, t.url, sql, t.table, t.opts)
+		t.body, err = clickhouse.Query(ctx, t.url, sql+limitSQL(t.limit), t.table, t.opts)
 	}
 
 	return err
@@ -7,10 +7,10 @@ import (
 	"net/url"
 	"sort"
 	"strings"
-	"time"
 
 	"github.com/go-graphite/carbonapi/pkg/parser"
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 type taggedTermOp int
@@ -41,36 +41,42 @@ func (s taggedTermList) Less(i, j int) bool {
 }
 
 type TaggedFinder struct {
-	url   string             // clickhouse dsn
-	table string             // graphite_tag table
-	opts  clickhouse.Options // clickhouse query timeout
-	body  []byte             // clickhouse response
+	url    string             // clickhouse dsn
+	table  string             // graphite_tag table
+	schema *schema.Schema     // column names of table
+	opts   clickhouse.Options // clickhouse query timeout
+	limit  int                // max rows of response, 0 - unlimited
+	body   []byte             // clickhouse response
 }
 
-func NewTagged(url string, table string, opts clickhouse.Options) *TaggedFinder {
+func NewTagged(url string, table string, s *schema.Schema, opts clickhouse.Options, limit int) *TaggedFinder {
 	return &TaggedFinder{
-		url:   url,
-		table: table,
-		opts:  opts,
+		url:    url,
+		table:  table,
+		schema: s,
+		opts:   opts,
+		limit:  limit,
 	}
 }
 
-func taggedTermWhere1(term *taggedTerm) string {
+func taggedTermWhere1(term *taggedTerm, s *schema.Schema) string {
 	switch term.op {
 	case taggedTermEq:
-		return fmt.Sprintf("Tag1=%s", Q(fmt.Sprintf("%s=%s", term.key, term.value)))
+		return fmt.Sprintf("%s=%s", s.Tag1, Q(fmt.Sprintf("%s=%s", term.key, term.value)))
 	case taggedTermNe:
-		return fmt.Sprintf("Tag1!=%s", Q(fmt.Sprintf("%s=%s", term.key, term.value)))
+		return fmt.Sprintf("%s!=%s", s.Tag1, Q(fmt.Sprintf("%s=%s", term.key, term.value)))
 	case taggedTermMatch:
 		return fmt.Sprintf(
-			"(Tag1 LIKE %s) AND (match(Tag1, %s))",
+			"(%[1]s LIKE %[2]s) AND (match(%[1]s, %[3]s))",
+			s.Tag1,
 			Q(fmt.Sprintf("%s=%%", term.key)),
 			Q(fmt.Sprintf("%s=%s", term.key, term.value)),
 		)
 
 	case taggedTermNotMatch:
 		return fmt.Sprintf(
-			"NOT ((Tag1 LIKE %s) AND (match(Tag1, %s)))",
+			"NOT ((%[1]s LIKE %[2]s) AND (match(%[1]s, %[3]s)))",
+			s.Tag1,
 			Q(fmt.Sprintf("%s=%%", term.key)),
 			Q(fmt.Sprintf("%s=%s", term.key, term.value)),
 		)
@@ -79,32 +85,34 @@ func taggedTermWhere1(term *taggedTerm) string {
 	}
 }
 
-func taggedTermWhereN(term *taggedTerm) string {
+func taggedTermWhereN(term *taggedTerm, s *schema.Schema) string {
 	// arrayExists((x) -> %s, Tags)
 	switch term.op {
 	case taggedTermEq:
-		return fmt.Sprintf("arrayExists((x) -> x=%s, Tags)", Q(fmt.Sprintf("%s=%s", term.key, term.value)))
+		return fmt.Sprintf("arrayExists((x) -> x=%s, %s)", Q(fmt.Sprintf("%s=%s", term.key, term.value)), s.Tags)
 	case taggedTermNe:
-		return fmt.Sprintf("NOT arrayExists((x) -> x=%s, Tags)", Q(fmt.Sprintf("%s=%s", term.key, term.value)))
+		return fmt.Sprintf("NOT arrayExists((x) -> x=%s, %s)", Q(fmt.Sprintf("%s=%s", term.key, term.value)), s.Tags)
 	case taggedTermMatch:
 		return fmt.Sprintf(
-			"arrayExists((x) -> (x LIKE %s) AND (match(x, %s)), Tags)",
+			"arrayExists((x) -> (x LIKE %s) AND (match(x, %s)), %s)",
 			Q(fmt.Sprintf("%s=%%", term.key)),
 			Q(fmt.Sprintf("%s=%s", term.key, term.value)),
+			s.Tags,
 		)
 
 	case taggedTermNotMatch:
 		return fmt.Sprintf(
-			"NOT arrayExists((x) -> (x LIKE %s) AND (match(x, %s)), Tags)",
+			"NOT arrayExists((x) -> (x LIKE %s) AND (match(x, %s)), %s)",
 			Q(fmt.Sprintf("%s=%%", term.key)),
 			Q(fmt.Sprintf("%s=%s", term.key, term.value)),
+			s.Tags,
 		)
 	default:
 		return ""
 	}
 }
 
-func MakeTaggedWhere(expr []string) (string, error) {
+func MakeTaggedWhere(expr []string, s *schema.Schema) (string, error) {
 	terms := make([]taggedTerm, len(expr))
 
 	for i := 0; i < len(expr); i++ {
@@ -154,10 +162,10 @@ func MakeTaggedWhere(expr []string) (string, error) {
 	sort.Sort(taggedTermList(terms))
 
 	w := NewWhere()
-	w.And(taggedTermWhere1(&terms[0]))
+	w.And(taggedTermWhere1(&terms[0], s))
 
 	for i := 1; i < len(terms); i++ {
-		w.And(taggedTermWhereN(&terms[i]))
+		w.And(taggedTermWhereN(&terms[i], s))
 	}
 
 	return w.String(), nil
@@ -199,7 +207,7 @@ func (t *TaggedFinder) makeWhere(query string) (string, error) {
 		conditions = append(conditions, s)
 	}
 
-	return MakeTaggedWhere(conditions)
+	return MakeTaggedWhere(conditions, t.schema)
 }
 
 func (t *TaggedFinder) Execute(ctx context.Context, query string, from int64, until int64) error {
@@ -208,14 +216,14 @@ func (t *TaggedFinder) Execute(ctx context.Context, query string, from int64, un
 		return err
 	}
 
-	dateWhere := NewWhere()
-	dateWhere.Andf(
-		"Date >='%s' AND Date <= '%s'",
-		time.Unix(from, 0).Format("2006-01-02"),
-		time.Unix(until, 0).Format("2006-01-02"),
-	)
+	where := NewWhere()
+	where.And(t.schema.DateWhere(from, until))
+	where.And(w)
 
-	sql := fmt.Sprintf("SELECT Path FROM %s WHERE (%s) AND (%s) GROUP BY Path HAVING argMax(Deleted, Version)==0", t.table, dateWhere.String(), w)
+	sql := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s GROUP BY %s HAVING %s%s",
+		t.schema.Path, t.table, where, t.schema.Path, t.schema.NotDeleted(), limitSQL(t.limit),
+	)
 	t.body, err = clickhouse.Query(ctx, t.url, sql, t.table, t.opts)
 	return err
 }
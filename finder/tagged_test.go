@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 func TestTaggedWhere(t *testing.T) {
@@ -32,7 +33,7 @@ func TestTaggedWhere(t *testing.T) {
 
 		srv := clickhouse.NewTestServer()
 
-		f := NewTagged(srv.URL, "tbl", clickhouse.Options{Timeout: time.Second, ConnectTimeout: time.Second})
+		f := NewTagged(srv.URL, "tbl", schema.Default(), clickhouse.Options{Timeout: time.Second, ConnectTimeout: time.Second}, 0)
 
 		w, err := f.makeWhere(test.query)
 
@@ -42,3 +43,14 @@ func TestTaggedWhere(t *testing.T) {
 		srv.Close()
 	}
 }
+
+func TestTaggedWhereSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &schema.Schema{Tag1: "FirstTag", Tags: "AllTags"}
+	s.SetDefaults()
+
+	w, err := MakeTaggedWhere([]string{"name=rps", "key=~value"}, s)
+	assert.NoError(err)
+	assert.Equal("(FirstTag='__name__=rps') AND (arrayExists((x) -> (x LIKE 'key=%') AND (match(x, 'key=value')), AllTags))", w)
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
 	"regexp"
 	"runtime"
 	"time"
@@ -15,6 +16,7 @@ import (
 	"github.com/lomik/graphite-clickhouse/autocomplete"
 	"github.com/lomik/graphite-clickhouse/config"
 	"github.com/lomik/graphite-clickhouse/find"
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
 	"github.com/lomik/graphite-clickhouse/helper/version"
 	"github.com/lomik/graphite-clickhouse/render"
 	"github.com/lomik/graphite-clickhouse/tagger"
@@ -84,15 +86,18 @@ func Handler(logger *zap.Logger, handler http.Handler) http.Handler {
 		)
 
 		start := time.Now()
+		// access is logged for aborted response too
+		defer func() {
+			d := time.Since(start)
+			logger.Info("access",
+				zap.Duration("time", d),
+				zap.String("method", r.Method),
+				zap.String("url", r.URL.String()),
+				zap.String("peer", r.RemoteAddr),
+				zap.Int("status", writer.Status()),
+			)
+		}()
 		handler.ServeHTTP(w, r)
-		d := time.Since(start)
-		logger.Info("access",
-			zap.Duration("time", d),
-			zap.String("method", r.Method),
-			zap.String("url", r.URL.String()),
-			zap.String("peer", r.RemoteAddr),
-			zap.Int("status", writer.Status()),
-		)
 	})
 }
 
@@ -129,8 +134,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// config parsed successfully. Exit in check-only mode
+	// tagger uploads tags with INSERT, which is supported by http interface only
+	if *buildTags && clickhouse.IsNative(cfg.ClickHouse.Url) {
+		log.Fatal("tagger requires http:// url of clickhouse, native tcp:// url is not supported")
+	}
+
+	// config parsed successfully. Check tables if enabled and exit in check-only mode
 	if *checkConfig {
+		if cfg.Common.CheckTables == config.CheckTablesOff {
+			return
+		}
+		problems := cfg.CheckTables(context.Background())
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
 		return
 	}
 
@@ -140,6 +160,17 @@ func main() {
 
 	runtime.GOMAXPROCS(cfg.Common.MaxCPU)
 
+	if cfg.Common.CheckTables != config.CheckTablesOff {
+		logger := zapwriter.Logger("check")
+		problems := cfg.CheckTables(context.Background())
+		for _, p := range problems {
+			logger.Warn("table", zap.String("problem", p))
+		}
+		if len(problems) > 0 && cfg.Common.CheckTables == config.CheckTablesFail {
+			log.Fatalf("%d problems with tables found", len(problems))
+		}
+	}
+
 	/* CONFIG end */
 
 	/* CONSOLE COMMANDS start */
@@ -152,6 +183,12 @@ func main() {
 
 	/* CONSOLE COMMANDS end */
 
+	if err = cfg.StartRollups(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+
+	clickhouse.StartHealthChecks(context.Background())
+
 	http.Handle("/metrics/find/", Handler(zapwriter.Default(), find.NewHandler(cfg)))
 	http.Handle("/render/", Handler(zapwriter.Default(), render.NewHandler(cfg)))
 	http.Handle("/tags/autoComplete/tags", Handler(zapwriter.Default(), autocomplete.NewTags(cfg)))
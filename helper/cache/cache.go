@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/graphite-clickhouse/helper/log"
+)
+
+// Cache is LRU cache of byte values with limited total size. Every value has own expiration time
+type Cache struct {
+	sync.Mutex
+	maxSize int64
+	size    int64
+	ll      *list.List // front is most recently used
+	items   map[string]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+type entry struct {
+	key      string
+	value    []byte
+	deadline time.Time
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// New creates cache with total size of keys and values limited by maxSize bytes
+func New(maxSize int64) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+// Get returns value if key exists and is not expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.items[key]
+	if ok && time.Now().After(el.Value.(*entry).deadline) {
+		c.remove(el)
+		ok = false
+	}
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Set stores value for ttl. Least recently used values are evicted if cache is full.
+// Values larger than cache size are not stored
+func (c *Cache) Set(key string, value []byte, ttl time.Duration) {
+	e := &entry{key: key, value: value, deadline: time.Now().Add(ttl)}
+	if e.size() > c.maxSize {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	c.items[key] = c.ll.PushFront(e)
+	c.size += e.size()
+
+	for c.size > c.maxSize {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.size -= e.size()
+}
+
+// Len returns number of stored values
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+// Stats returns total number of hits and misses
+func (c *Cache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// Log writes result of lookup and total hits and misses of cache named name to request logger
+func (c *Cache) Log(ctx context.Context, name string, hit bool) {
+	hits, misses := c.Stats()
+	log.FromContext(ctx).Info("cache",
+		zap.String("cache", name),
+		zap.Bool("hit", hit),
+		zap.Uint64("hits", hits),
+		zap.Uint64("misses", misses),
+	)
+}
+
+// NoCache returns true if request has graphite-web compatible noCache parameter
+func NoCache(r *http.Request) bool {
+	v := r.FormValue("noCache")
+	return v != "" && v != "0" && v != "false"
+}
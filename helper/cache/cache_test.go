@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c := New(20)
+
+	c.Set("a", []byte("123456789"), time.Minute)
+	c.Set("b", []byte("123456789"), time.Minute)
+
+	v, ok := c.Get("a")
+	assert.True(ok)
+	assert.Equal("123456789", string(v))
+
+	// "b" is least recently used
+	c.Set("c", []byte("123"), time.Minute)
+	_, ok = c.Get("b")
+	assert.False(ok)
+	_, ok = c.Get("a")
+	assert.True(ok)
+	_, ok = c.Get("c")
+	assert.True(ok)
+	assert.Equal(2, c.Len())
+
+	// too large value
+	c.Set("d", []byte("12345678901234567890"), time.Minute)
+	_, ok = c.Get("d")
+	assert.False(ok)
+	assert.Equal(2, c.Len())
+
+	// expired
+	c.Set("a", []byte("1"), -time.Second)
+	_, ok = c.Get("a")
+	assert.False(ok)
+	assert.Equal(1, c.Len())
+
+	hits, misses := c.Stats()
+	assert.Equal(uint64(3), hits)
+	assert.Equal(uint64(3), misses)
+}
@@ -0,0 +1,185 @@
+package clickhouse
+
+import "encoding/binary"
+
+// CityHash128 of version 1.0.2 is used by ClickHouse for checksums of compressed blocks.
+// Port of https://github.com/google/cityhash (MIT license)
+
+const (
+	cityK0  uint64 = 0xc3a5c85c97cb3127
+	cityK1  uint64 = 0xb492b66fbe98f273
+	cityK2  uint64 = 0x9ae16a3b2f90404f
+	cityK3  uint64 = 0xc949d7c7509e6557
+	cityMul uint64 = 0x9ddfea08eb382d69
+)
+
+type uint128 struct {
+	lo, hi uint64
+}
+
+func cityFetch64(p []byte) uint64 {
+	return binary.LittleEndian.Uint64(p)
+}
+
+func cityFetch32(p []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(p))
+}
+
+func cityRotate(v uint64, shift uint) uint64 {
+	if shift == 0 {
+		return v
+	}
+	return (v >> shift) | (v << (64 - shift))
+}
+
+func cityShiftMix(v uint64) uint64 {
+	return v ^ (v >> 47)
+}
+
+func cityHashLen16(u, v uint64) uint64 {
+	a := (u ^ v) * cityMul
+	a ^= a >> 47
+	b := (v ^ a) * cityMul
+	b ^= b >> 47
+	return b * cityMul
+}
+
+func cityHashLen0to16(s []byte) uint64 {
+	l := uint64(len(s))
+	if l > 8 {
+		a := cityFetch64(s)
+		b := cityFetch64(s[l-8:])
+		return cityHashLen16(a, cityRotate(b+l, uint(l))) ^ b
+	}
+	if l >= 4 {
+		a := cityFetch32(s)
+		return cityHashLen16(l+(a<<3), cityFetch32(s[l-4:]))
+	}
+	if l > 0 {
+		a := uint64(s[0])
+		b := uint64(s[l>>1])
+		c := uint64(s[l-1])
+		y := a + (b << 8)
+		z := l + (c << 2)
+		return cityShiftMix(y*cityK2^z*cityK3) * cityK2
+	}
+	return cityK2
+}
+
+func cityWeakHashLen32WithSeeds(s []byte, a, b uint64) uint128 {
+	w, x, y, z := cityFetch64(s), cityFetch64(s[8:]), cityFetch64(s[16:]), cityFetch64(s[24:])
+	a += w
+	b = cityRotate(b+a+z, 21)
+	c := a
+	a += x
+	a += y
+	b += cityRotate(a, 44)
+	return uint128{a + z, b + c}
+}
+
+func cityMurmur(s []byte, seed uint128) uint128 {
+	a, b := seed.lo, seed.hi
+	var c, d uint64
+	l := len(s) - 16
+
+	if l <= 0 {
+		a = cityShiftMix(a*cityK1) * cityK1
+		c = b*cityK1 + cityHashLen0to16(s)
+		if len(s) >= 8 {
+			d = cityShiftMix(a + cityFetch64(s))
+		} else {
+			d = cityShiftMix(a + c)
+		}
+	} else {
+		c = cityHashLen16(cityFetch64(s[len(s)-8:])+cityK1, a)
+		d = cityHashLen16(b+uint64(len(s)), c+cityFetch64(s[len(s)-16:]))
+		a += d
+		for {
+			a ^= cityShiftMix(cityFetch64(s)*cityK1) * cityK1
+			a *= cityK1
+			b ^= a
+			c ^= cityShiftMix(cityFetch64(s[8:])*cityK1) * cityK1
+			c *= cityK1
+			d ^= c
+			s = s[16:]
+			l -= 16
+			if l <= 0 {
+				break
+			}
+		}
+	}
+
+	a = cityHashLen16(a, c)
+	b = cityHashLen16(d, b)
+	return uint128{a ^ b, cityHashLen16(b, a)}
+}
+
+func cityHash128WithSeed(s []byte, seed uint128) uint128 {
+	if len(s) < 128 {
+		return cityMurmur(s, seed)
+	}
+
+	t := s
+	l := len(s)
+	pos := 0
+
+	x, y := seed.lo, seed.hi
+	z := uint64(l) * cityK1
+
+	var v, w uint128
+	v.lo = cityRotate(y^cityK1, 49)*cityK1 + cityFetch64(s)
+	v.hi = cityRotate(v.lo, 42)*cityK1 + cityFetch64(s[8:])
+	w.lo = cityRotate(y+z, 35)*cityK1 + x
+	w.hi = cityRotate(x+cityFetch64(s[88:]), 53) * cityK1
+
+	for {
+		for i := 0; i < 2; i++ {
+			x = cityRotate(x+y+v.lo+cityFetch64(s[16:]), 37) * cityK1
+			y = cityRotate(y+v.hi+cityFetch64(s[48:]), 42) * cityK1
+			x ^= w.hi
+			y ^= v.lo
+			z = cityRotate(z^w.lo, 33)
+			v = cityWeakHashLen32WithSeeds(s, v.hi*cityK1, x+w.lo)
+			w = cityWeakHashLen32WithSeeds(s[32:], z+w.hi, y)
+			z, x = x, z
+			s = s[64:]
+			pos += 64
+		}
+		l -= 128
+		if l < 128 {
+			break
+		}
+	}
+
+	y += cityRotate(w.lo, 37)*cityK0 + z
+	x += cityRotate(v.lo+z, 49) * cityK0
+
+	// hash up to 4 chunks of 32 bytes from the end of s
+	for done := 0; done < l; {
+		done += 32
+		y = cityRotate(y-x, 42)*cityK0 + v.hi
+		w.lo += cityFetch64(t[pos+l-done+16:])
+		x = cityRotate(x, 49)*cityK0 + w.lo
+		w.lo += v.lo
+		v = cityWeakHashLen32WithSeeds(t[pos+l-done:], v.lo, v.hi)
+	}
+
+	x = cityHashLen16(x, v.lo)
+	y = cityHashLen16(y, w.lo)
+
+	return uint128{
+		cityHashLen16(x+v.hi, w.hi) + y,
+		cityHashLen16(x+w.hi, y+v.hi),
+	}
+}
+
+func cityHash128(s []byte) uint128 {
+	l := len(s)
+	if l >= 16 {
+		return cityHash128WithSeed(s[16:], uint128{cityFetch64(s) ^ cityK3, cityFetch64(s[8:])})
+	}
+	if l >= 8 {
+		return cityHash128WithSeed(nil, uint128{cityFetch64(s) ^ (uint64(l) * cityK0), cityFetch64(s[l-8:]) ^ cityK1})
+	}
+	return cityHash128WithSeed(s, uint128{cityK0, cityK1})
+}
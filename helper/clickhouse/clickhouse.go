@@ -1,6 +1,7 @@
 package clickhouse
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"errors"
@@ -8,10 +9,10 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
-	"net"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lomik/graphite-clickhouse/helper/version"
@@ -27,8 +28,13 @@ var ErrClickHouseResponse = errors.New("Malformed response from clickhouse")
 type Options struct {
 	Timeout        time.Duration
 	ConnectTimeout time.Duration
+	Settings       map[string]string // extra clickhouse settings of query
+	direct         bool              // query is sent to dsn without balancing and not killed on cancel, used by kill query itself
 }
 
+// killQueryTimeout limits KILL QUERY of abandoned query
+const killQueryTimeout = 5 * time.Second
+
 func formatSQL(q string) string {
 	s := strings.Split(q, "\n")
 	for i := 0; i < len(s); i++ {
@@ -57,10 +63,15 @@ func PostGzip(ctx context.Context, dsn string, query string, table string, postB
 }
 
 func Reader(ctx context.Context, dsn string, query string, table string, opts Options) (io.ReadCloser, error) {
-	return reader(ctx, dsn, query, table, nil, false, opts)
+	return reader(ctx, dsn, query, table, nil, false, nil, opts)
+}
+
+// ReaderExternal executes query with temporary table extData
+func ReaderExternal(ctx context.Context, dsn string, query string, table string, extData *ExternalData, opts Options) (io.ReadCloser, error) {
+	return reader(ctx, dsn, query, table, nil, false, extData, opts)
 }
 
-func reader(ctx context.Context, dsn string, query string, table string, postBody io.Reader, gzip bool, opts Options) (bodyReader io.ReadCloser, err error) {
+func reader(ctx context.Context, dsn string, query string, table string, postBody io.Reader, gzip bool, extData *ExternalData, opts Options) (bodyReader io.ReadCloser, err error) {
 	start := time.Now()
 
 	var requestID string
@@ -87,6 +98,69 @@ func reader(ctx context.Context, dsn string, query string, table string, postBod
 		}
 	}()
 
+	r := &request{
+		query:     query,
+		table:     table,
+		requestID: requestID,
+		postBody:  postBody,
+		gzip:      gzip,
+		extData:   extData,
+		opts:      opts,
+	}
+
+	if extData != nil {
+		r.extBody, r.contentType, err = extData.body()
+		if err != nil {
+			return
+		}
+	}
+
+	c := getCluster(dsn)
+	if c == nil || opts.direct {
+		bodyReader, _, err = r.do(ctx, dsn)
+		return
+	}
+
+	// body of caller can't be sent twice
+	retry := postBody == nil && isIdempotent(query)
+
+	if retry && c.opts.HedgeDelay > 0 && len(c.replicas) > 1 {
+		bodyReader, err = c.hedged(ctx, r, logger)
+		return
+	}
+
+	tried := make(map[*replica]bool)
+	for {
+		rp := c.pick(tried)
+		tried[rp] = true
+
+		var failed bool
+		bodyReader, failed, err = c.attempt(ctx, r, rp, logger)
+		if !failed || !retry || len(tried) == len(c.replicas) || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// request is query prepared to be sent to any replica
+type request struct {
+	query       string
+	table       string
+	requestID   string
+	postBody    io.Reader // body of caller, query is sent in params
+	extBody     []byte    // multipart body of external data
+	contentType string
+	gzip        bool
+	extData     *ExternalData
+	opts        Options
+}
+
+// do sends request to single dsn. Result failed is true if dsn is unavailable and query may be sent to another replica
+func (r *request) do(ctx context.Context, dsn string) (bodyReader io.ReadCloser, failed bool, err error) {
+	if IsNative(dsn) {
+		return r.doNative(ctx, dsn)
+	}
+
 	p, err := url.Parse(dsn)
 	if err != nil {
 		return
@@ -97,57 +171,141 @@ func reader(ctx context.Context, dsn string, query string, table string, postBod
 	queryID := fmt.Sprintf("%x", b)
 
 	q := p.Query()
-	q.Set("query_id", fmt.Sprintf("%s::%s", requestID, queryID))
-	p.RawQuery = q.Encode()
+	for name, value := range r.opts.Settings {
+		q.Set(name, value)
+	}
+	fullQueryID := fmt.Sprintf("%s::%s", r.requestID, queryID)
+	q.Set("query_id", fullQueryID)
+
+	var postBody io.Reader
+	if r.extData != nil {
+		r.extData.setParams(q)
+		postBody = bytes.NewReader(r.extBody)
+	} else if r.postBody != nil {
+		postBody = r.postBody
+	}
 
 	if postBody != nil {
-		q := p.Query()
-		q.Set("query", query)
-		p.RawQuery = q.Encode()
+		q.Set("query", r.query)
 	} else {
-		postBody = strings.NewReader(query)
+		postBody = strings.NewReader(r.query)
 	}
+	p.RawQuery = q.Encode()
 
-	url := p.String()
-
-	req, err := http.NewRequest("POST", url, postBody)
+	req, err := http.NewRequest("POST", p.String(), postBody)
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("User-Agent", fmt.Sprintf("graphite-clickhouse/%s (table:%s)", version.Version, table))
+	// connections are shared between queries, so query timeout is applied to request context
+	parent := ctx
+	var cancel context.CancelFunc
+	if r.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, r.opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	req = req.WithContext(ctx)
+
+	// finished is closed before cancel of context when response is received
+	finished := make(chan struct{})
+	var finishOnce sync.Once
+	finish := func() {
+		finishOnce.Do(func() { close(finished) })
+		cancel()
+	}
+
+	if !r.opts.direct {
+		// query keeps running in clickhouse after client gone. Kill it if request is canceled or timed out
+		go func() {
+			select {
+			case <-finished:
+			case <-ctx.Done():
+				select {
+				case <-finished:
+					return
+				default:
+				}
+				if parent.Err() != nil || ctx.Err() == context.DeadlineExceeded {
+					killQuery(dsn, fullQueryID, r.table, r.opts)
+				}
+			}
+		}()
+	}
+
+	req.Header.Add("User-Agent", fmt.Sprintf("graphite-clickhouse/%s (table:%s)", version.Version, r.table))
 
-	if gzip {
+	if r.gzip {
 		req.Header.Add("Content-Encoding", "gzip")
 	}
 
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+
 	client := &http.Client{
-		Timeout: opts.Timeout,
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout: opts.ConnectTimeout,
-			}).Dial,
-			DisableKeepAlives: true,
-		},
+		Transport: transports.get(p.Scheme, p.Host, r.opts.ConnectTimeout),
 	}
 	resp, err := client.Do(req)
 	if err != nil {
+		// replica is unavailable if request is not canceled or timed out
+		failed = ctx.Err() == nil
+		// query may be already started if request is canceled
+		cancel()
 		return
 	}
 
 	if resp.StatusCode != 200 {
 		body, _ := ioutil.ReadAll(resp.Body)
 		resp.Body.Close()
+		finish()
+		// gateway errors of proxy in front of unavailable clickhouse
+		failed = resp.StatusCode == http.StatusBadGateway ||
+			resp.StatusCode == http.StatusServiceUnavailable ||
+			resp.StatusCode == http.StatusGatewayTimeout
 		err = fmt.Errorf("clickhouse response status %d: %s", resp.StatusCode, string(body))
 		return
 	}
 
-	bodyReader = resp.Body
+	bodyReader = &onCloseReader{ReadCloser: resp.Body, onClose: finish}
 	return
 }
 
+// onCloseReader calls onClose after response body is closed
+type onCloseReader struct {
+	io.ReadCloser
+	onClose func()
+}
+
+func (r *onCloseReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.onClose()
+	return err
+}
+
+// killQuery stops abandoned query with queryID on clickhouse server
+func killQuery(dsn string, queryID string, table string, opts Options) {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+
+	// query is killed on the same replica
+	do(ctx, dsn, fmt.Sprintf("KILL QUERY WHERE query_id='%s' ASYNC", Escape(queryID)), table, nil, false,
+		Options{Timeout: killQueryTimeout, ConnectTimeout: opts.ConnectTimeout, direct: true})
+}
+
+// isIdempotent returns true for read-only queries which may be retried on another replica
+func isIdempotent(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	for _, prefix := range []string{"SELECT", "WITH", "DESCRIBE", "DESC ", "SHOW", "EXISTS"} {
+		if strings.HasPrefix(q, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func do(ctx context.Context, dsn string, query string, table string, postBody io.Reader, gzip bool, opts Options) ([]byte, error) {
-	bodyReader, err := reader(ctx, dsn, query, table, postBody, gzip, opts)
+	bodyReader, err := reader(ctx, dsn, query, table, postBody, gzip, nil, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,70 @@
+package clickhouse
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillQuery(t *testing.T) {
+	assert := assert.New(t)
+
+	queryID := make(chan string, 1)
+	killed := make(chan string, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		query := string(body)
+
+		if strings.HasPrefix(query, "KILL QUERY") {
+			killed <- query
+			return
+		}
+
+		if query == "SELECT sleep" {
+			queryID <- r.URL.Query().Get("query_id")
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	opts := Options{Timeout: time.Second, ConnectTimeout: time.Second}
+
+	// finished query is not killed
+	_, err := Query(context.WithValue(context.Background(), "requestID", "req"), srv.URL, "SELECT 1", "", opts)
+	assert.NoError(err)
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "requestID", "req"))
+	started := make(chan string, 1)
+	go func() {
+		started <- <-queryID
+		cancel()
+	}()
+
+	_, err = Query(ctx, srv.URL, "SELECT sleep", "", opts)
+	assert.Error(err)
+
+	select {
+	case query := <-killed:
+		id := <-started
+		assert.True(strings.HasPrefix(id, "req::"), id)
+		assert.Equal("KILL QUERY WHERE query_id='"+id+"' ASYNC", query)
+	case <-time.After(time.Second):
+		t.Fatal("query not killed")
+	}
+
+	select {
+	case query := <-killed:
+		t.Fatalf("unexpected %s", query)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
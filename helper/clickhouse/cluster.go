@@ -0,0 +1,394 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// Balance policies of queries between replicas
+const (
+	BalanceRoundRobin  = "round-robin"
+	BalanceLeastLoaded = "least-loaded"
+)
+
+// ClusterOptions of balancing between replicas
+type ClusterOptions struct {
+	Balance             string        // BalanceRoundRobin or BalanceLeastLoaded
+	EjectTime           time.Duration // failed replica is not used for eject time
+	HealthCheckInterval time.Duration // interval of /ping probes of replicas. 0 - disabled
+	HedgeDelay          time.Duration // read query is duplicated to another replica if no response headers after delay. 0 - disabled
+	HedgePercentile     float64       // if set, delay is percentile of recent response times, but not less than HedgeDelay. Requires HedgeDelay
+}
+
+// latencySize is count of recent response times used for hedge delay percentile
+const latencySize = 1000
+
+// latencyMinCount is count of response times required for hedge delay percentile
+const latencyMinCount = 100
+
+type replica struct {
+	dsn          string
+	host         string // for logs, dsn may contain password
+	active       int64  // queries in progress
+	ejectedUntil int64  // unix nano, set on query error
+	down         int32  // 1 if last health probe failed
+}
+
+func (r *replica) eject(d time.Duration) {
+	atomic.StoreInt64(&r.ejectedUntil, time.Now().Add(d).UnixNano())
+}
+
+func (r *replica) healthy(now int64) bool {
+	return atomic.LoadInt32(&r.down) == 0 && atomic.LoadInt64(&r.ejectedUntil) <= now
+}
+
+// cluster balances queries to dsn between replicas
+type cluster struct {
+	replicas []*replica
+	opts     ClusterOptions
+	next     uint64
+	stop     chan struct{}
+
+	latencyMu    sync.Mutex
+	latency      []time.Duration // ring of recent response times
+	latencyIndex int
+}
+
+var clusters = struct {
+	sync.RWMutex
+	m map[string]*cluster
+}{m: make(map[string]*cluster)}
+
+// SetReplicas replaces balanced clusters. Queries to every dsn key of replicas are balanced
+// between dsn itself and its replicas. Previous clusters and their health checks are stopped.
+// Health checks of new clusters are started with StartHealthChecks
+func SetReplicas(replicas map[string][]string, opts ClusterOptions) error {
+	switch opts.Balance {
+	case BalanceRoundRobin, BalanceLeastLoaded:
+	default:
+		return fmt.Errorf("unknown balance %#v", opts.Balance)
+	}
+
+	if opts.HedgePercentile < 0 || opts.HedgePercentile > 100 {
+		return fmt.Errorf("hedge percentile should be in [0, 100]")
+	}
+
+	// percentile only raises the delay, hedging is enabled by HedgeDelay
+	if opts.HedgePercentile > 0 && opts.HedgeDelay <= 0 {
+		return fmt.Errorf("hedge percentile requires hedge delay > 0")
+	}
+
+	m := make(map[string]*cluster)
+	for dsn, list := range replicas {
+		if len(list) == 0 {
+			continue
+		}
+
+		c := &cluster{
+			replicas: make([]*replica, 0, len(list)+1),
+			opts:     opts,
+			stop:     make(chan struct{}),
+			latency:  make([]time.Duration, 0, latencySize),
+		}
+
+		for _, d := range append([]string{dsn}, list...) {
+			u, err := url.Parse(d)
+			if err != nil {
+				return err
+			}
+			c.replicas = append(c.replicas, &replica{dsn: d, host: u.Host})
+		}
+
+		m[dsn] = c
+	}
+
+	clusters.Lock()
+	for _, c := range clusters.m {
+		close(c.stop)
+	}
+	clusters.m = m
+	clusters.Unlock()
+
+	return nil
+}
+
+// StartHealthChecks starts /ping probes of replicas of current clusters if health check interval is set.
+// Probes are stopped when ctx is done or clusters are replaced by SetReplicas
+func StartHealthChecks(ctx context.Context) {
+	clusters.RLock()
+	defer clusters.RUnlock()
+
+	for _, c := range clusters.m {
+		if c.opts.HealthCheckInterval > 0 {
+			go c.healthCheck(ctx)
+		}
+	}
+}
+
+func getCluster(dsn string) *cluster {
+	clusters.RLock()
+	c := clusters.m[dsn]
+	clusters.RUnlock()
+	return c
+}
+
+// pick returns healthy replica not tried yet. If all such replicas are unhealthy any of them is returned
+func (c *cluster) pick(tried map[*replica]bool) *replica {
+	now := time.Now().UnixNano()
+	n := len(c.replicas)
+	start := int(atomic.AddUint64(&c.next, 1) % uint64(n))
+
+	var result, fallback *replica
+	for i := 0; i < n; i++ {
+		r := c.replicas[(start+i)%n]
+		if tried[r] {
+			continue
+		}
+		if fallback == nil {
+			fallback = r
+		}
+		if !r.healthy(now) {
+			continue
+		}
+		if c.opts.Balance == BalanceRoundRobin {
+			return r
+		}
+		if result == nil || atomic.LoadInt64(&r.active) < atomic.LoadInt64(&result.active) {
+			result = r
+		}
+	}
+
+	if result != nil {
+		return result
+	}
+	return fallback
+}
+
+// attempt sends request to replica rp and tracks its state
+func (c *cluster) attempt(ctx context.Context, r *request, rp *replica, logger *zap.Logger) (io.ReadCloser, bool, error) {
+	start := time.Now()
+
+	atomic.AddInt64(&rp.active, 1)
+	body, failed, err := r.do(ctx, rp.dsn)
+	if err != nil {
+		atomic.AddInt64(&rp.active, -1)
+	} else {
+		c.addLatency(time.Since(start))
+		body = &onCloseReader{ReadCloser: body, onClose: func() { atomic.AddInt64(&rp.active, -1) }}
+	}
+
+	if failed {
+		rp.eject(c.opts.EjectTime)
+		logger.Warn("replica failed", zap.String("replica", rp.host), zap.Error(err))
+	}
+
+	return body, failed, err
+}
+
+type attemptResult struct {
+	index  int // number of attempt
+	body   io.ReadCloser
+	failed bool
+	err    error
+	cancel context.CancelFunc
+}
+
+// hedged sends request to another replica if the first one has not responded within hedge delay.
+// The first response is returned, queries of other replicas are canceled
+func (c *cluster) hedged(ctx context.Context, r *request, logger *zap.Logger) (io.ReadCloser, error) {
+	results := make(chan *attemptResult, len(c.replicas))
+	cancels := make([]context.CancelFunc, 0, len(c.replicas))
+	tried := make(map[*replica]bool)
+	running := 0
+
+	start := func() {
+		rp := c.pick(tried)
+		tried[rp] = true
+		running++
+
+		actx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		res := &attemptResult{index: len(cancels) - 1, cancel: cancel}
+		go func() {
+			res.body, res.failed, res.err = c.attempt(actx, r, rp, logger)
+			results <- res
+		}()
+	}
+
+	// cancelOthers cancels queries of running attempts except winner and closes their responses in background
+	cancelOthers := func(winner int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+
+		n := running
+		go func() {
+			for i := 0; i < n; i++ {
+				res := <-results
+				if res.err == nil {
+					res.body.Close()
+				}
+				res.cancel()
+			}
+		}()
+	}
+
+	delay := c.hedgeDelay()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	start()
+
+	var err error
+	for running > 0 {
+		select {
+		case <-timer.C:
+			if len(tried) < len(c.replicas) {
+				logger.Info("hedge", zap.Duration("delay", delay))
+				start()
+			}
+		case res := <-results:
+			running--
+			if res.err == nil {
+				cancelOthers(res.index)
+				return &onCloseReader{ReadCloser: res.body, onClose: res.cancel}, nil
+			}
+			res.cancel()
+			err = res.err
+
+			if !res.failed {
+				// query error, the same is expected from any replica
+				cancelOthers(-1)
+				return nil, err
+			}
+
+			if running == 0 && len(tried) < len(c.replicas) && ctx.Err() == nil {
+				start()
+			}
+		}
+	}
+
+	return nil, err
+}
+
+func (c *cluster) addLatency(d time.Duration) {
+	c.latencyMu.Lock()
+	if len(c.latency) < latencySize {
+		c.latency = append(c.latency, d)
+	} else {
+		c.latency[c.latencyIndex] = d
+		c.latencyIndex = (c.latencyIndex + 1) % latencySize
+	}
+	c.latencyMu.Unlock()
+}
+
+// hedgeDelay returns HedgeDelay or percentile of recent response times if greater
+func (c *cluster) hedgeDelay() time.Duration {
+	if c.opts.HedgePercentile <= 0 {
+		return c.opts.HedgeDelay
+	}
+
+	c.latencyMu.Lock()
+	if len(c.latency) < latencyMinCount {
+		c.latencyMu.Unlock()
+		return c.opts.HedgeDelay
+	}
+	latency := make([]time.Duration, len(c.latency))
+	copy(latency, c.latency)
+	c.latencyMu.Unlock()
+
+	sort.Slice(latency, func(i, j int) bool { return latency[i] < latency[j] })
+
+	i := int(float64(len(latency))*c.opts.HedgePercentile/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latency) {
+		i = len(latency) - 1
+	}
+
+	if latency[i] > c.opts.HedgeDelay {
+		return latency[i]
+	}
+	return c.opts.HedgeDelay
+}
+
+func (c *cluster) healthCheck(ctx context.Context) {
+	logger := zapwriter.Logger("health")
+	ticker := time.NewTicker(c.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range c.replicas {
+			var err error
+			if IsNative(r.dsn) {
+				err = pingNative(r.dsn, c.opts.HealthCheckInterval)
+			} else {
+				err = ping(r.dsn, c.opts.HealthCheckInterval)
+			}
+			if err != nil {
+				if atomic.SwapInt32(&r.down, 1) == 0 {
+					logger.Warn("replica down", zap.String("replica", r.host), zap.Error(err))
+				}
+				continue
+			}
+			if atomic.SwapInt32(&r.down, 0) == 1 {
+				logger.Info("replica up", zap.String("replica", r.host))
+			}
+			// passive ejection is cleared by successful probe
+			atomic.StoreInt64(&r.ejectedUntil, 0)
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ping checks /ping handler of clickhouse server of dsn
+func ping(dsn string, timeout time.Duration) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return err
+	}
+	u.Path = "/ping"
+	u.RawQuery = ""
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: transports.get(u.Scheme, u.Host, timeout)}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("ping status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package clickhouse
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	var queries int32
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		w.Write([]byte("ok"))
+	}))
+	defer alive.Close()
+
+	err := SetReplicas(map[string][]string{dead.URL: {alive.URL}}, ClusterOptions{Balance: BalanceRoundRobin, EjectTime: time.Minute})
+	assert.NoError(err)
+	defer SetReplicas(nil, ClusterOptions{Balance: BalanceRoundRobin})
+
+	opts := Options{Timeout: time.Second, ConnectTimeout: time.Second}
+	for i := 0; i < 4; i++ {
+		body, err := Query(context.Background(), dead.URL, "SELECT 1", "", opts)
+		assert.NoError(err)
+		assert.Equal("ok", string(body))
+	}
+	assert.Equal(int32(4), atomic.LoadInt32(&queries))
+
+	c := getCluster(dead.URL)
+	assert.False(c.replicas[0].healthy(time.Now().UnixNano()))
+	assert.True(c.replicas[1].healthy(time.Now().UnixNano()))
+
+	// all replicas are ejected, query is sent anyway
+	c.replicas[1].eject(time.Minute)
+	_, err = Query(context.Background(), dead.URL, "SELECT 1", "", opts)
+	assert.NoError(err)
+}
+
+func TestClusterPick(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &cluster{
+		replicas: []*replica{{dsn: "a"}, {dsn: "b"}, {dsn: "c"}},
+		opts:     ClusterOptions{Balance: BalanceLeastLoaded},
+	}
+
+	c.replicas[0].active = 2
+	c.replicas[1].active = 1
+	c.replicas[2].active = 3
+	for i := 0; i < 3; i++ {
+		assert.Equal("b", c.pick(nil).dsn)
+	}
+
+	c.replicas[1].eject(time.Minute)
+	assert.Equal("a", c.pick(nil).dsn)
+	assert.Equal("c", c.pick(map[*replica]bool{c.replicas[0]: true}).dsn)
+	// only ejected replica is not tried
+	assert.Equal("b", c.pick(map[*replica]bool{c.replicas[0]: true, c.replicas[2]: true}).dsn)
+
+	c.opts.Balance = BalanceRoundRobin
+	c.replicas[1].ejectedUntil = 0
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		seen[c.pick(nil).dsn]++
+	}
+	assert.Equal(map[string]int{"a": 2, "b": 2, "c": 2}, seen)
+}
+
+func TestClusterHealthCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	var down int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ping" && atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("Ok.\n"))
+	}))
+	defer srv.Close()
+
+	err := SetReplicas(map[string][]string{"http://replica1": {srv.URL + "/?user=default"}}, ClusterOptions{
+		Balance:             BalanceRoundRobin,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	assert.NoError(err)
+	defer SetReplicas(nil, ClusterOptions{Balance: BalanceRoundRobin})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartHealthChecks(ctx)
+
+	r := getCluster("http://replica1").replicas[1]
+
+	wait := func(healthy bool) bool {
+		for i := 0; i < 100; i++ {
+			if r.healthy(time.Now().UnixNano()) == healthy {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	assert.True(wait(false))
+	atomic.StoreInt32(&down, 0)
+	assert.True(wait(true))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isIdempotent("SELECT 1"))
+	assert.True(isIdempotent("\n\tselect Path FROM graphite"))
+	assert.True(isIdempotent("DESCRIBE TABLE graphite FORMAT TabSeparated"))
+	assert.False(isIdempotent("INSERT INTO graphite_tag (Date,Version,Level,Path,IsLeaf,Tags,Tag1) FORMAT RowBinary"))
+	assert.False(isIdempotent("KILL QUERY WHERE query_id='1' ASYNC"))
+}
+
+func TestClusterHedged(t *testing.T) {
+	assert := assert.New(t)
+
+	killed := make(chan string, 10)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(string(body), "KILL QUERY") {
+			killed <- string(body)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	err := SetReplicas(map[string][]string{slow.URL: {fast.URL}}, ClusterOptions{
+		Balance:    BalanceRoundRobin,
+		EjectTime:  time.Minute,
+		HedgeDelay: 20 * time.Millisecond,
+	})
+	assert.NoError(err)
+	defer SetReplicas(nil, ClusterOptions{Balance: BalanceRoundRobin})
+
+	opts := Options{Timeout: 5 * time.Second, ConnectTimeout: time.Second}
+
+	// round-robin sends one of queries to slow replica first
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		body, err := Query(context.Background(), slow.URL, "SELECT 1", "", opts)
+		assert.NoError(err)
+		assert.Equal("fast", string(body))
+		assert.True(time.Since(start) < 500*time.Millisecond)
+	}
+
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("query of slow replica not killed")
+	}
+
+	// slow replica is not ejected
+	assert.True(getCluster(slow.URL).replicas[0].healthy(time.Now().UnixNano()))
+}
+
+func TestClusterHedgeDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	c := &cluster{
+		opts: ClusterOptions{HedgeDelay: 50 * time.Millisecond, HedgePercentile: 95},
+	}
+
+	for i := 1; i <= latencyMinCount-1; i++ {
+		c.addLatency(time.Duration(i) * time.Millisecond)
+	}
+	// not enough response times
+	assert.Equal(50*time.Millisecond, c.hedgeDelay())
+
+	c.addLatency(100 * time.Millisecond)
+	assert.Equal(95*time.Millisecond, c.hedgeDelay())
+
+	c.opts.HedgeDelay = 200 * time.Millisecond
+	assert.Equal(200*time.Millisecond, c.hedgeDelay())
+
+	// ring keeps recent response times only
+	for i := 0; i < latencySize; i++ {
+		c.addLatency(time.Millisecond)
+	}
+	c.opts.HedgeDelay = 0
+	assert.Equal(time.Millisecond, c.hedgeDelay())
+
+	// percentile without delay does not enable hedging
+	assert.Error(SetReplicas(nil, ClusterOptions{Balance: BalanceRoundRobin, HedgePercentile: 95}))
+}
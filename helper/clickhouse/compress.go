@@ -0,0 +1,273 @@
+package clickhouse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Compressed block of native protocol:
+// checksum (16 bytes CityHash128 of the rest), method (1 byte),
+// compressed size with header (4 bytes), decompressed size (4 bytes), data
+
+const (
+	compressMethodNone = 0x02
+	compressMethodLZ4  = 0x82
+
+	compressChecksumSize = 16
+	compressHeaderSize   = 9
+	compressMaxBlockSize = 128 * 1024 * 1024
+)
+
+var errLZ4Corrupted = errors.New("lz4: corrupted block")
+
+// lz4Decompress decompresses LZ4 block src to dst and returns size of decompressed data
+func lz4Decompress(src, dst []byte) (int, error) {
+	si, di := 0, 0
+
+	for si < len(src) {
+		token := src[si]
+		si++
+
+		// literals
+		n := int(token >> 4)
+		if n == 15 {
+			for {
+				if si >= len(src) {
+					return 0, errLZ4Corrupted
+				}
+				b := src[si]
+				si++
+				n += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if si+n > len(src) || di+n > len(dst) {
+			return 0, errLZ4Corrupted
+		}
+		copy(dst[di:], src[si:si+n])
+		si += n
+		di += n
+
+		// the last sequence has literals only
+		if si == len(src) {
+			break
+		}
+
+		// match
+		if si+2 > len(src) {
+			return 0, errLZ4Corrupted
+		}
+		offset := int(src[si]) | int(src[si+1])<<8
+		si += 2
+		if offset == 0 || offset > di {
+			return 0, errLZ4Corrupted
+		}
+
+		n = int(token & 15)
+		if n == 15 {
+			for {
+				if si >= len(src) {
+					return 0, errLZ4Corrupted
+				}
+				b := src[si]
+				si++
+				n += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		n += 4
+		if di+n > len(dst) {
+			return 0, errLZ4Corrupted
+		}
+		// match may overlap output, copy byte by byte
+		for i := 0; i < n; i++ {
+			dst[di+i] = dst[di-offset+i]
+		}
+		di += n
+	}
+
+	return di, nil
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// lz4AppendSequence appends literals and match of length matchLen (0 - no match) to dst
+func lz4AppendSequence(dst []byte, literals []byte, offset int, matchLen int) []byte {
+	var token byte
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	if matchLen > 0 {
+		if matchLen-4 >= 15 {
+			token |= 15
+		} else {
+			token |= byte(matchLen - 4)
+		}
+	}
+
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if matchLen-4 >= 15 {
+			dst = lz4AppendLength(dst, matchLen-4-15)
+		}
+	}
+
+	return dst
+}
+
+// lz4Compress returns LZ4 block of src compressed with simple greedy matching
+func lz4Compress(src []byte) []byte {
+	const (
+		hashLog      = 16
+		mfLimit      = 12 // match doesn't start in last 12 bytes
+		lastLiterals = 5  // last 5 bytes are literals
+		maxOffset    = 65535
+	)
+
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	table := make([]int32, 1<<hashLog) // position + 1
+
+	anchor := 0
+	for i := 0; i < len(src)-mfLimit; {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * 2654435761) >> (32 - hashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+
+		n := 4
+		for i+n < len(src)-lastLiterals && src[ref+n] == src[i+n] {
+			n++
+		}
+
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, n)
+		i += n
+		anchor = i
+	}
+
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// compressBlock returns data compressed with LZ4 as block of native protocol
+func compressBlock(data []byte) []byte {
+	compressed := lz4Compress(data)
+
+	block := make([]byte, compressChecksumSize+compressHeaderSize+len(compressed))
+	block[compressChecksumSize] = compressMethodLZ4
+	binary.LittleEndian.PutUint32(block[compressChecksumSize+1:], uint32(compressHeaderSize+len(compressed)))
+	binary.LittleEndian.PutUint32(block[compressChecksumSize+5:], uint32(len(data)))
+	copy(block[compressChecksumSize+compressHeaderSize:], compressed)
+
+	h := cityHash128(block[compressChecksumSize:])
+	binary.LittleEndian.PutUint64(block[0:], h.lo)
+	binary.LittleEndian.PutUint64(block[8:], h.hi)
+
+	return block
+}
+
+// compressedReader reads data of compressed blocks. New block is read only when data of previous one
+// is exhausted, so reader never reads beyond the last block of packet
+type compressedReader struct {
+	r    io.Reader
+	raw  []byte
+	data []byte
+	pos  int
+}
+
+func (c *compressedReader) readBlock() error {
+	header := make([]byte, compressChecksumSize+compressHeaderSize)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+
+	method := header[compressChecksumSize]
+	rawSize := int(binary.LittleEndian.Uint32(header[compressChecksumSize+1:])) - compressHeaderSize
+	dataSize := int(binary.LittleEndian.Uint32(header[compressChecksumSize+5:]))
+
+	if rawSize < 0 || rawSize > compressMaxBlockSize || dataSize < 0 || dataSize > compressMaxBlockSize {
+		return fmt.Errorf("compressed block: bad size %d/%d", rawSize, dataSize)
+	}
+
+	if cap(c.raw) < len(header)+rawSize {
+		c.raw = make([]byte, len(header)+rawSize)
+	}
+	c.raw = c.raw[:len(header)+rawSize]
+	copy(c.raw, header)
+	if _, err := io.ReadFull(c.r, c.raw[len(header):]); err != nil {
+		return err
+	}
+
+	h := cityHash128(c.raw[compressChecksumSize:])
+	if h.lo != binary.LittleEndian.Uint64(header[0:]) || h.hi != binary.LittleEndian.Uint64(header[8:]) {
+		return errors.New("compressed block: checksum mismatch")
+	}
+
+	if cap(c.data) < dataSize {
+		c.data = make([]byte, dataSize)
+	}
+	c.data = c.data[:dataSize]
+	c.pos = 0
+
+	switch method {
+	case compressMethodLZ4:
+		n, err := lz4Decompress(c.raw[len(header):], c.data)
+		if err != nil {
+			return err
+		}
+		if n != dataSize {
+			return fmt.Errorf("compressed block: decompressed %d bytes, expected %d", n, dataSize)
+		}
+	case compressMethodNone:
+		copy(c.data, c.raw[len(header):])
+	default:
+		return fmt.Errorf("compressed block: unsupported method 0x%02x", method)
+	}
+
+	return nil
+}
+
+func (c *compressedReader) Read(p []byte) (int, error) {
+	if c.pos >= len(c.data) {
+		if err := c.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.data[c.pos:])
+	c.pos += n
+	return n, nil
+}
+
+func (c *compressedReader) ReadByte() (byte, error) {
+	if c.pos >= len(c.data) {
+		if err := c.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	b := c.data[c.pos]
+	c.pos++
+	return b, nil
+}
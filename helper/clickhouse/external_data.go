@@ -0,0 +1,63 @@
+package clickhouse
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/url"
+)
+
+// ExternalData is a temporary table sent to clickhouse together with query
+// as multipart/form-data file. Query can use it by name: "... WHERE Path IN _ext"
+type ExternalData struct {
+	Name      string // table name
+	Structure string // columns, e.g. "Path String"
+	Format    string // format of data, e.g. "RowBinary"
+	buf       bytes.Buffer
+}
+
+func NewExternalData(name string, structure string, format string) *ExternalData {
+	return &ExternalData{
+		Name:      name,
+		Structure: structure,
+		Format:    format,
+	}
+}
+
+// Write appends raw data in Format to table body
+func (e *ExternalData) Write(p []byte) (int, error) {
+	return e.buf.Write(p)
+}
+
+// Bytes returns table body
+func (e *ExternalData) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// setParams adds structure and format of table to query params
+func (e *ExternalData) setParams(q url.Values) {
+	q.Set(e.Name+"_structure", e.Structure)
+	if e.Format != "" {
+		q.Set(e.Name+"_format", e.Format)
+	}
+}
+
+// body returns multipart/form-data request body and its content type
+func (e *ExternalData) body() ([]byte, string, error) {
+	b := new(bytes.Buffer)
+	w := multipart.NewWriter(b)
+
+	part, err := w.CreateFormFile(e.Name, e.Name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err = part.Write(e.buf.Bytes()); err != nil {
+		return nil, "", err
+	}
+
+	if err = w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return b.Bytes(), w.FormDataContentType(), nil
+}
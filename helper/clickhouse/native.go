@@ -0,0 +1,702 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Native TCP protocol of ClickHouse is used for dsn with tcp:// scheme:
+// tcp://localhost:9000/?user=default&password=&database=default&compress=1&max_threads=4
+// Parameters except user, password, database and compress are sent as settings of queries.
+// Columnar blocks are converted to the format requested by query (RowBinary, TabSeparated or JSON),
+// so callers read the same response with any transport.
+//
+// Existing clients are not used: github.com/ClickHouse/clickhouse-go v1 is a database/sql driver (go 1.12+),
+// so rows would be scanned into values and encoded back to the formats parsed by finders and render, and
+// github.com/ClickHouse/ch-go requires go 1.18 while graphite-clickhouse builds with go 1.7. Only the subset
+// needed here is implemented: SELECT with external data, ping and LZ4 compressed blocks. Packets follow
+// clickhouse-go v1.4.3 and ch-go v0.58, CityHash128 checksum is tested with reference vectors of clickhouse-go.
+// Queries with data (INSERT of tagger) are not supported, tagger requires http:// url
+
+const (
+	nativeScheme = "tcp"
+
+	nativeClientName = "graphite-clickhouse"
+	// settings are serialized as strings since this revision
+	nativeRevision = 54429
+)
+
+// codes of client packets
+const (
+	nativeClientHello = 0
+	nativeClientQuery = 1
+	nativeClientData  = 2
+	nativeClientPing  = 4
+)
+
+// codes of server packets
+const (
+	nativeServerHello        = 0
+	nativeServerData         = 1
+	nativeServerException    = 2
+	nativeServerProgress     = 3
+	nativeServerPong         = 4
+	nativeServerEndOfStream  = 5
+	nativeServerProfileInfo  = 6
+	nativeServerTotals       = 7
+	nativeServerExtremes     = 8
+	nativeServerLog          = 10
+	nativeServerTableColumns = 11
+)
+
+// revisions of protocol features
+const (
+	nativeRevisionTimezone    = 54058
+	nativeRevisionDisplayName = 54372
+	nativeRevisionPatch       = 54401
+	nativeRevisionWriteInfo   = 54420
+)
+
+var formatRe = regexp.MustCompile(`(?i)\bFORMAT\s+(\w+)\s*;?\s*$`)
+
+// queryFormat returns output format of query, TabSeparated by default as in HTTP interface
+func queryFormat(query string) string {
+	if m := formatRe.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return "TabSeparated"
+}
+
+// nativeDSN is parsed dsn of native protocol
+type nativeDSN struct {
+	addr     string
+	user     string
+	password string
+	database string
+	compress bool
+	settings map[string]string
+}
+
+func parseNativeDSN(dsn string) (*nativeDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &nativeDSN{
+		addr:     u.Host,
+		user:     "default",
+		compress: true,
+		settings: make(map[string]string),
+	}
+
+	if u.Port() == "" {
+		d.addr = net.JoinHostPort(u.Hostname(), "9000")
+	}
+
+	if u.User != nil {
+		d.user = u.User.Username()
+		d.password, _ = u.User.Password()
+	}
+
+	for name, values := range u.Query() {
+		value := values[len(values)-1]
+		switch name {
+		case "user":
+			d.user = value
+		case "password":
+			d.password = value
+		case "database":
+			d.database = value
+		case "compress":
+			d.compress = value != "0" && value != "false"
+		default:
+			d.settings[name] = value
+		}
+	}
+
+	return d, nil
+}
+
+// nativeException is error sent by server
+type nativeException struct {
+	code    int32
+	name    string
+	message string
+}
+
+func (e *nativeException) Error() string {
+	return fmt.Sprintf("clickhouse exception code %d: %s", e.code, e.message)
+}
+
+// nativeConn is connection of native protocol
+type nativeConn struct {
+	dsn      string
+	conn     net.Conn
+	r        *bufio.Reader
+	compress bool
+	revision uint64
+	loc      *time.Location
+	lastUsed time.Time
+}
+
+func dialNative(ctx context.Context, dsn string, d *nativeDSN, connectTimeout time.Duration) (*nativeConn, error) {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: transports.options().KeepAlive,
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &nativeConn{
+		dsn:      dsn,
+		conn:     conn,
+		r:        bufio.NewReader(conn),
+		compress: d.compress,
+		loc:      time.UTC,
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if connectTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(connectTimeout))
+	}
+
+	if err = c.hello(d); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *nativeConn) hello(d *nativeDSN) error {
+	b := appendUvarint(nil, nativeClientHello)
+	b = appendString(b, nativeClientName)
+	b = appendUvarint(b, 1) // client version
+	b = appendUvarint(b, 0)
+	b = appendUvarint(b, nativeRevision)
+	b = appendString(b, d.database)
+	b = appendString(b, d.user)
+	b = appendString(b, d.password)
+
+	if _, err := c.conn.Write(b); err != nil {
+		return err
+	}
+
+	code, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case nativeServerHello:
+	case nativeServerException:
+		return c.readException()
+	default:
+		return fmt.Errorf("unexpected packet %d on hello", code)
+	}
+
+	if _, err = readString(c.r); err != nil { // server name
+		return err
+	}
+	for i := 0; i < 2; i++ { // major and minor version
+		if _, err = binary.ReadUvarint(c.r); err != nil {
+			return err
+		}
+	}
+	revision, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return err
+	}
+
+	c.revision = revision
+	if c.revision > nativeRevision {
+		c.revision = nativeRevision
+	}
+	if c.revision < nativeRevision {
+		return fmt.Errorf("clickhouse revision %d is too old for native protocol, %d required", revision, nativeRevision)
+	}
+
+	if c.revision >= nativeRevisionTimezone {
+		tz, err := readString(c.r)
+		if err != nil {
+			return err
+		}
+		if loc, err := time.LoadLocation(tz); err == nil {
+			c.loc = loc
+		}
+	}
+	if c.revision >= nativeRevisionDisplayName {
+		if _, err = readString(c.r); err != nil {
+			return err
+		}
+	}
+	if c.revision >= nativeRevisionPatch {
+		if _, err = binary.ReadUvarint(c.r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *nativeConn) readException() error {
+	var err error
+	e := &nativeException{}
+
+	var b [4]byte
+	if _, err = io.ReadFull(c.r, b[:]); err != nil {
+		return err
+	}
+	e.code = int32(binary.LittleEndian.Uint32(b[:]))
+
+	if e.name, err = readString(c.r); err != nil {
+		return err
+	}
+	if e.message, err = readString(c.r); err != nil {
+		return err
+	}
+	if _, err = readString(c.r); err != nil { // stack trace
+		return err
+	}
+	nested, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if nested != 0 {
+		// the first exception is enough, but nested ones must be read to keep connection usable
+		c.readException()
+	}
+
+	return e
+}
+
+// appendBlock appends data packet with block of external table name
+func (c *nativeConn) appendBlock(b []byte, name string, block []byte) []byte {
+	b = appendUvarint(b, nativeClientData)
+	b = appendString(b, name)
+	if c.compress {
+		return append(b, compressBlock(block)...)
+	}
+	return append(b, block...)
+}
+
+// sendQuery sends query with settings and external data
+func (c *nativeConn) sendQuery(queryID string, query string, settings map[string]string, extData *ExternalData) error {
+	hostname, _ := os.Hostname()
+
+	b := appendUvarint(nil, nativeClientQuery)
+	b = appendString(b, queryID)
+
+	// client info
+	b = append(b, 1) // initial query
+	b = appendString(b, "")
+	b = appendString(b, "")
+	b = appendString(b, "0.0.0.0:0")
+	b = append(b, 1) // TCP interface
+	b = appendString(b, "")
+	b = appendString(b, hostname)
+	b = appendString(b, nativeClientName)
+	b = appendUvarint(b, 1)
+	b = appendUvarint(b, 0)
+	b = appendUvarint(b, nativeRevision)
+	b = appendString(b, "") // quota key
+	b = appendUvarint(b, 0) // version patch
+
+	for name, value := range settings {
+		b = appendString(b, name)
+		b = appendUvarint(b, 1) // important, unknown setting is error
+		b = appendString(b, value)
+	}
+	b = appendString(b, "")
+
+	b = appendUvarint(b, 2) // complete stage
+	if c.compress {
+		b = appendUvarint(b, 1)
+	} else {
+		b = appendUvarint(b, 0)
+	}
+	b = appendString(b, query)
+
+	if extData != nil {
+		block, err := externalBlock(extData)
+		if err != nil {
+			return err
+		}
+		b = c.appendBlock(b, extData.Name, block)
+	}
+
+	// empty block is end of external tables
+	empty := appendBlockInfo(nil)
+	empty = appendUvarint(empty, 0)
+	empty = appendUvarint(empty, 0)
+	b = c.appendBlock(b, "", empty)
+
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// readPacket reads packet from server. Block is returned for data packet only
+func (c *nativeConn) readPacket() (uint64, *nativeBlock, error) {
+	code, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	switch code {
+	case nativeServerData, nativeServerTotals, nativeServerExtremes, nativeServerLog:
+		if _, err = readString(c.r); err != nil { // table name
+			return code, nil, err
+		}
+		var r byteReader = c.r
+		// logs are never compressed
+		if c.compress && code != nativeServerLog {
+			r = &compressedReader{r: c.r}
+		}
+		block, err := readBlock(r, c.loc)
+		if err != nil {
+			return code, nil, err
+		}
+		if code == nativeServerData {
+			return code, block, nil
+		}
+		return code, nil, nil
+	case nativeServerException:
+		return code, nil, c.readException()
+	case nativeServerProgress:
+		n := 3 // rows, bytes, total rows
+		if c.revision >= nativeRevisionWriteInfo {
+			n += 2 // written rows and bytes
+		}
+		for i := 0; i < n; i++ {
+			if _, err = binary.ReadUvarint(c.r); err != nil {
+				return code, nil, err
+			}
+		}
+		return code, nil, nil
+	case nativeServerProfileInfo:
+		// rows, blocks, bytes, applied limit, rows before limit, calculated rows before limit
+		for _, isByte := range []bool{false, false, false, true, false, true} {
+			if isByte {
+				_, err = c.r.ReadByte()
+			} else {
+				_, err = binary.ReadUvarint(c.r)
+			}
+			if err != nil {
+				return code, nil, err
+			}
+		}
+		return code, nil, nil
+	case nativeServerTableColumns:
+		for i := 0; i < 2; i++ {
+			if _, err = readString(c.r); err != nil {
+				return code, nil, err
+			}
+		}
+		return code, nil, nil
+	case nativeServerEndOfStream, nativeServerPong:
+		return code, nil, nil
+	}
+
+	return code, nil, fmt.Errorf("unexpected packet %d", code)
+}
+
+func (c *nativeConn) ping(timeout time.Duration) error {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(appendUvarint(nil, nativeClientPing)); err != nil {
+		return err
+	}
+
+	for {
+		code, _, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if code == nativeServerPong {
+			return nil
+		}
+		if code != nativeServerProgress {
+			return fmt.Errorf("unexpected packet %d on ping", code)
+		}
+	}
+}
+
+// nativePool keeps idle connections of every dsn
+type nativePool struct {
+	sync.Mutex
+	idle map[string][]*nativeConn
+}
+
+var nativeConns = &nativePool{idle: make(map[string][]*nativeConn)}
+
+func (p *nativePool) get(dsn string) *nativeConn {
+	opts := transports.options()
+
+	p.Lock()
+	defer p.Unlock()
+
+	for {
+		idle := p.idle[dsn]
+		if len(idle) == 0 {
+			return nil
+		}
+		c := idle[len(idle)-1]
+		p.idle[dsn] = idle[:len(idle)-1]
+		if opts.IdleConnTimeout > 0 && time.Since(c.lastUsed) > opts.IdleConnTimeout {
+			c.conn.Close()
+			continue
+		}
+		return c
+	}
+}
+
+func (p *nativePool) put(c *nativeConn) {
+	opts := transports.options()
+
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.idle[c.dsn]) >= opts.MaxIdleConnsPerHost {
+		c.conn.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+	p.idle[c.dsn] = append(p.idle[c.dsn], c)
+}
+
+func (p *nativePool) closeIdle() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, idle := range p.idle {
+		for _, c := range idle {
+			c.conn.Close()
+		}
+	}
+	p.idle = make(map[string][]*nativeConn)
+}
+
+// nativeResponse reads blocks of query result and returns them converted to output format
+type nativeResponse struct {
+	c      *nativeConn
+	format nativeFormat
+	buf    bytes.Buffer
+	done   bool
+	err    error
+	// release is called once on close with true if connection may be reused
+	release func(reuse bool)
+}
+
+// next reads packets until data block, end of stream or error
+func (r *nativeResponse) next() {
+	for !r.done && r.err == nil {
+		code, block, err := r.c.readPacket()
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		switch code {
+		case nativeServerData:
+			r.format.block(&r.buf, block)
+			return
+		case nativeServerEndOfStream:
+			r.format.end(&r.buf)
+			r.done = true
+			return
+		}
+	}
+}
+
+func (r *nativeResponse) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 && !r.done && r.err == nil {
+		r.next()
+	}
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
+
+func (r *nativeResponse) Close() error {
+	if r.release != nil {
+		r.release(r.done && r.err == nil)
+		r.release = nil
+	}
+	return nil
+}
+
+// doNative sends request with native protocol. Result failed is true if dsn is unavailable
+func (r *request) doNative(ctx context.Context, dsn string) (bodyReader io.ReadCloser, failed bool, err error) {
+	if r.postBody != nil {
+		err = errors.New("queries with data are not supported by native protocol client")
+		return
+	}
+
+	d, err := parseNativeDSN(dsn)
+	if err != nil {
+		return
+	}
+
+	format, err := newNativeFormat(queryFormat(r.query))
+	if err != nil {
+		return
+	}
+
+	settings := d.settings
+	if len(r.opts.Settings) > 0 {
+		settings = make(map[string]string)
+		for name, value := range d.settings {
+			settings[name] = value
+		}
+		for name, value := range r.opts.Settings {
+			settings[name] = value
+		}
+	}
+
+	var cancel context.CancelFunc
+	if r.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], rand.Uint64())
+	queryID := fmt.Sprintf("%s::%x", r.requestID, b)
+
+	// idle connection may be closed by server, query is retried once with new connection then
+	for pooled := true; ; pooled = false {
+		var c *nativeConn
+		if pooled {
+			c = nativeConns.get(dsn)
+			if c == nil {
+				continue
+			}
+		} else {
+			c, err = dialNative(ctx, dsn, d, r.opts.ConnectTimeout)
+			if err != nil {
+				failed = ctx.Err() == nil
+				cancel()
+				return
+			}
+		}
+
+		var resp *nativeResponse
+		resp, err = c.query(ctx, cancel, queryID, r.query, settings, r.extData, format)
+		if err == nil {
+			bodyReader = resp
+			return
+		}
+
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			cancel()
+			return
+		}
+
+		if _, ok := err.(*nativeException); ok {
+			cancel()
+			return
+		}
+
+		if !pooled {
+			failed = true
+			cancel()
+			return
+		}
+	}
+}
+
+// query sends query and returns response after the first block. Connection is closed by cancel of ctx,
+// so server cancels query. cancel is called on close of response
+func (c *nativeConn) query(ctx context.Context, cancel context.CancelFunc, queryID string, query string,
+	settings map[string]string, extData *ExternalData, format nativeFormat) (*nativeResponse, error) {
+
+	finished := make(chan struct{})
+	var once sync.Once
+	release := func(reuse bool) {
+		once.Do(func() {
+			close(finished)
+			if reuse && ctx.Err() == nil {
+				nativeConns.put(c)
+			} else {
+				c.conn.Close()
+			}
+			cancel()
+		})
+	}
+
+	go func() {
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			c.conn.Close()
+		}
+	}()
+
+	if err := c.sendQuery(queryID, query, settings, extData); err != nil {
+		c.conn.Close()
+		close(finished)
+		return nil, err
+	}
+
+	resp := &nativeResponse{c: c, format: format, release: release}
+
+	// exception is returned before the first block as in HTTP interface
+	resp.next()
+	if resp.err != nil {
+		c.conn.Close()
+		close(finished)
+		return nil, resp.err
+	}
+
+	return resp, nil
+}
+
+// pingNative checks native protocol connection to dsn
+func pingNative(dsn string, timeout time.Duration) error {
+	d, err := parseNativeDSN(dsn)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c, err := dialNative(ctx, dsn, d, timeout)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	return c.ping(timeout)
+}
+
+// IsNative returns true for dsn of native TCP protocol
+func IsNative(dsn string) bool {
+	return strings.HasPrefix(dsn, nativeScheme+"://")
+}
@@ -0,0 +1,677 @@
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteReader reads exactly requested bytes, so packets after block are not consumed
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readString(r byteReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > compressMaxBlockSize {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// nativeColumn is column of block received with native protocol
+type nativeColumn interface {
+	// rowBinary writes value of row i as RowBinary format does
+	rowBinary(w *bytes.Buffer, i int)
+	// text writes value of row i escaped as TabSeparated format does or quoted as in arrays
+	text(w *bytes.Buffer, i int, quoted bool)
+	// json writes value of row i as JSON format does
+	json(w *bytes.Buffer, i int)
+}
+
+// fixedSizes of types with values of fixed size
+var fixedSizes = map[string]int{
+	"UInt8":    1,
+	"UInt16":   2,
+	"UInt32":   4,
+	"UInt64":   8,
+	"Int8":     1,
+	"Int16":    2,
+	"Int32":    4,
+	"Int64":    8,
+	"Float32":  4,
+	"Float64":  8,
+	"Bool":     1,
+	"Date":     2,
+	"DateTime": 4,
+}
+
+// typeArgument returns argument of parametric type name(argument)
+func typeArgument(t string, name string) (string, bool) {
+	if strings.HasPrefix(t, name+"(") && strings.HasSuffix(t, ")") {
+		return t[len(name)+1 : len(t)-1], true
+	}
+	return "", false
+}
+
+// readColumn reads rows values of column with type t
+func readColumn(r byteReader, t string, rows int, loc *time.Location) (nativeColumn, error) {
+	if arg, ok := typeArgument(t, "DateTime"); ok {
+		tz, err := time.LoadLocation(strings.Trim(arg, "'"))
+		if err != nil {
+			return nil, err
+		}
+		c, err := readFixedColumn(r, "DateTime", 4, rows)
+		if err != nil {
+			return nil, err
+		}
+		c.loc = tz
+		return c, nil
+	}
+
+	if size, ok := fixedSizes[t]; ok {
+		c, err := readFixedColumn(r, t, size, rows)
+		if err != nil {
+			return nil, err
+		}
+		c.loc = loc
+		return c, nil
+	}
+
+	if t == "String" {
+		c := &stringColumn{offsets: make([]int, rows+1)}
+		var data bytes.Buffer
+		for i := 0; i < rows; i++ {
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if n > compressMaxBlockSize {
+				return nil, fmt.Errorf("string of %d bytes is too long", n)
+			}
+			if _, err = io.CopyN(&data, r, int64(n)); err != nil {
+				return nil, err
+			}
+			c.offsets[i+1] = data.Len()
+		}
+		c.data = data.Bytes()
+		return c, nil
+	}
+
+	if arg, ok := typeArgument(t, "FixedString"); ok {
+		size, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad type %s", t)
+		}
+		c, err := readFixedColumn(r, "FixedString", size, rows)
+		if err != nil {
+			return nil, err
+		}
+		return &fixedStringColumn{c}, nil
+	}
+
+	if arg, ok := typeArgument(t, "Nullable"); ok {
+		nulls := make([]byte, rows)
+		if _, err := io.ReadFull(r, nulls); err != nil {
+			return nil, err
+		}
+		inner, err := readColumn(r, arg, rows, loc)
+		if err != nil {
+			return nil, err
+		}
+		return &nullableColumn{nulls: nulls, inner: inner}, nil
+	}
+
+	if arg, ok := typeArgument(t, "Array"); ok {
+		offsets := make([]uint64, rows+1)
+		for i := 0; i < rows; i++ {
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
+			offsets[i+1] = binary.LittleEndian.Uint64(b[:])
+			if offsets[i+1] < offsets[i] || offsets[i+1] > compressMaxBlockSize {
+				return nil, fmt.Errorf("bad offsets of %s", t)
+			}
+		}
+		inner, err := readColumn(r, arg, int(offsets[rows]), loc)
+		if err != nil {
+			return nil, err
+		}
+		return &arrayColumn{offsets: offsets, inner: inner}, nil
+	}
+
+	return nil, fmt.Errorf("type %s is not supported by native protocol client", t)
+}
+
+type fixedColumn struct {
+	t    string
+	size int
+	data []byte
+	loc  *time.Location
+}
+
+func readFixedColumn(r byteReader, t string, size int, rows int) (*fixedColumn, error) {
+	if size*rows > compressMaxBlockSize {
+		return nil, fmt.Errorf("column of %d rows is too big", rows)
+	}
+	c := &fixedColumn{t: t, size: size, data: make([]byte, size*rows)}
+	if _, err := io.ReadFull(r, c.data); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *fixedColumn) value(i int) []byte {
+	return c.data[i*c.size : (i+1)*c.size]
+}
+
+func (c *fixedColumn) rowBinary(w *bytes.Buffer, i int) {
+	w.Write(c.value(i))
+}
+
+func (c *fixedColumn) text(w *bytes.Buffer, i int, quoted bool) {
+	v := c.value(i)
+	switch c.t {
+	case "UInt8", "Bool":
+		w.WriteString(strconv.FormatUint(uint64(v[0]), 10))
+	case "UInt16":
+		w.WriteString(strconv.FormatUint(uint64(binary.LittleEndian.Uint16(v)), 10))
+	case "UInt32":
+		w.WriteString(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(v)), 10))
+	case "UInt64":
+		w.WriteString(strconv.FormatUint(binary.LittleEndian.Uint64(v), 10))
+	case "Int8":
+		w.WriteString(strconv.FormatInt(int64(int8(v[0])), 10))
+	case "Int16":
+		w.WriteString(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(v))), 10))
+	case "Int32":
+		w.WriteString(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(v))), 10))
+	case "Int64":
+		w.WriteString(strconv.FormatInt(int64(binary.LittleEndian.Uint64(v)), 10))
+	case "Float32":
+		w.WriteString(formatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(v))), 32))
+	case "Float64":
+		w.WriteString(formatFloat(math.Float64frombits(binary.LittleEndian.Uint64(v)), 64))
+	case "Date":
+		d := time.Unix(int64(binary.LittleEndian.Uint16(v))*86400, 0).UTC()
+		writeQuoted(w, d.Format("2006-01-02"), quoted)
+	case "DateTime":
+		t := time.Unix(int64(binary.LittleEndian.Uint32(v)), 0).In(c.loc)
+		writeQuoted(w, t.Format("2006-01-02 15:04:05"), quoted)
+	}
+}
+
+func (c *fixedColumn) json(w *bytes.Buffer, i int) {
+	switch c.t {
+	case "UInt64", "Int64", "Date", "DateTime":
+		// 64-bit integers are quoted by default
+		var b bytes.Buffer
+		c.text(&b, i, false)
+		writeJSONString(w, b.String())
+	case "Float32", "Float64":
+		var b bytes.Buffer
+		c.text(&b, i, false)
+		if s := b.String(); s == "inf" || s == "-inf" || s == "nan" {
+			w.WriteString("null")
+		} else {
+			w.WriteString(s)
+		}
+	default:
+		c.text(w, i, false)
+	}
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+type stringColumn struct {
+	offsets []int
+	data    []byte
+}
+
+func (c *stringColumn) value(i int) []byte {
+	return c.data[c.offsets[i]:c.offsets[i+1]]
+}
+
+func (c *stringColumn) rowBinary(w *bytes.Buffer, i int) {
+	v := c.value(i)
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(v)))])
+	w.Write(v)
+}
+
+func (c *stringColumn) text(w *bytes.Buffer, i int, quoted bool) {
+	writeEscaped(w, c.value(i), quoted)
+}
+
+func (c *stringColumn) json(w *bytes.Buffer, i int) {
+	writeJSONString(w, string(c.value(i)))
+}
+
+type fixedStringColumn struct {
+	*fixedColumn
+}
+
+func (c *fixedStringColumn) text(w *bytes.Buffer, i int, quoted bool) {
+	writeEscaped(w, c.value(i), quoted)
+}
+
+func (c *fixedStringColumn) json(w *bytes.Buffer, i int) {
+	writeJSONString(w, string(c.value(i)))
+}
+
+type nullableColumn struct {
+	nulls []byte
+	inner nativeColumn
+}
+
+func (c *nullableColumn) rowBinary(w *bytes.Buffer, i int) {
+	w.WriteByte(c.nulls[i])
+	if c.nulls[i] == 0 {
+		c.inner.rowBinary(w, i)
+	}
+}
+
+func (c *nullableColumn) text(w *bytes.Buffer, i int, quoted bool) {
+	if c.nulls[i] != 0 {
+		if quoted {
+			w.WriteString("NULL")
+		} else {
+			w.WriteString(`\N`)
+		}
+		return
+	}
+	c.inner.text(w, i, quoted)
+}
+
+func (c *nullableColumn) json(w *bytes.Buffer, i int) {
+	if c.nulls[i] != 0 {
+		w.WriteString("null")
+		return
+	}
+	c.inner.json(w, i)
+}
+
+type arrayColumn struct {
+	offsets []uint64
+	inner   nativeColumn
+}
+
+func (c *arrayColumn) rowBinary(w *bytes.Buffer, i int) {
+	var buf [binary.MaxVarintLen64]byte
+	w.Write(buf[:binary.PutUvarint(buf[:], c.offsets[i+1]-c.offsets[i])])
+	for j := c.offsets[i]; j < c.offsets[i+1]; j++ {
+		c.inner.rowBinary(w, int(j))
+	}
+}
+
+func (c *arrayColumn) text(w *bytes.Buffer, i int, quoted bool) {
+	w.WriteByte('[')
+	for j := c.offsets[i]; j < c.offsets[i+1]; j++ {
+		if j > c.offsets[i] {
+			w.WriteByte(',')
+		}
+		c.inner.text(w, int(j), true)
+	}
+	w.WriteByte(']')
+}
+
+func (c *arrayColumn) json(w *bytes.Buffer, i int) {
+	w.WriteByte('[')
+	for j := c.offsets[i]; j < c.offsets[i+1]; j++ {
+		if j > c.offsets[i] {
+			w.WriteByte(',')
+		}
+		c.inner.json(w, int(j))
+	}
+	w.WriteByte(']')
+}
+
+// writeEscaped writes string escaped as TabSeparated does or in single quotes as values in arrays
+func writeEscaped(w *bytes.Buffer, s []byte, quoted bool) {
+	if quoted {
+		w.WriteByte('\'')
+	}
+	for _, b := range s {
+		switch b {
+		case '\\':
+			w.WriteString(`\\`)
+		case '\t':
+			w.WriteString(`\t`)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\b':
+			w.WriteString(`\b`)
+		case '\f':
+			w.WriteString(`\f`)
+		case 0:
+			w.WriteString(`\0`)
+		case '\'':
+			if quoted {
+				w.WriteString(`\'`)
+			} else {
+				w.WriteByte(b)
+			}
+		default:
+			w.WriteByte(b)
+		}
+	}
+	if quoted {
+		w.WriteByte('\'')
+	}
+}
+
+func writeQuoted(w *bytes.Buffer, s string, quoted bool) {
+	if quoted {
+		w.WriteByte('\'')
+		w.WriteString(s)
+		w.WriteByte('\'')
+	} else {
+		w.WriteString(s)
+	}
+}
+
+func writeJSONString(w *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	w.Write(b)
+}
+
+// nativeBlock is block of columns received with native protocol
+type nativeBlock struct {
+	rows    int
+	names   []string
+	types   []string
+	columns []nativeColumn
+}
+
+func readBlock(r byteReader, loc *time.Location) (*nativeBlock, error) {
+	// block info: pairs of field number and value ended with 0
+	for {
+		field, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if field == 0 {
+			break
+		}
+		switch field {
+		case 1: // is_overflows
+			if _, err = r.ReadByte(); err != nil {
+				return nil, err
+			}
+		case 2: // bucket_num
+			var b [4]byte
+			if _, err = io.ReadFull(r, b[:]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown field %d of block info", field)
+		}
+	}
+
+	columns, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if columns > 1000000 || rows > compressMaxBlockSize {
+		return nil, fmt.Errorf("bad block of %d columns and %d rows", columns, rows)
+	}
+
+	b := &nativeBlock{
+		rows:    int(rows),
+		names:   make([]string, columns),
+		types:   make([]string, columns),
+		columns: make([]nativeColumn, columns),
+	}
+
+	for i := 0; i < int(columns); i++ {
+		if b.names[i], err = readString(r); err != nil {
+			return nil, err
+		}
+		if b.types[i], err = readString(r); err != nil {
+			return nil, err
+		}
+		if b.columns[i], err = readColumn(r, b.types[i], b.rows, loc); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// appendBlockInfo appends default block info
+func appendBlockInfo(b []byte) []byte {
+	b = appendUvarint(b, 1) // is_overflows
+	b = append(b, 0)
+	b = appendUvarint(b, 2) // bucket_num
+	b = append(b, 0xff, 0xff, 0xff, 0xff)
+	return appendUvarint(b, 0)
+}
+
+// externalBlock converts RowBinary rows of external data to block of columns.
+// Only columns of fixed size types and String are supported
+func externalBlock(e *ExternalData) ([]byte, error) {
+	names, types := splitStructure(e.Structure)
+
+	sizes := make([]int, len(types))
+	for i, t := range types {
+		if t == "String" {
+			continue
+		}
+		size, ok := fixedSizes[t]
+		if !ok {
+			return nil, fmt.Errorf("type %s of external data is not supported by native protocol client", t)
+		}
+		sizes[i] = size
+	}
+
+	if e.Format != "" && e.Format != "RowBinary" {
+		return nil, fmt.Errorf("format %s of external data is not supported by native protocol client", e.Format)
+	}
+
+	columns := make([][]byte, len(types))
+	data := e.Bytes()
+	rows := 0
+	for len(data) > 0 {
+		for i := range types {
+			n := sizes[i]
+			if n == 0 {
+				l, k := binary.Uvarint(data)
+				if k <= 0 {
+					return nil, ErrUvarintRead
+				}
+				n = k + int(l)
+			}
+			if n > len(data) {
+				return nil, ErrClickHouseResponse
+			}
+			columns[i] = append(columns[i], data[:n]...)
+			data = data[n:]
+		}
+		rows++
+	}
+
+	b := appendBlockInfo(nil)
+	b = appendUvarint(b, uint64(len(types)))
+	b = appendUvarint(b, uint64(rows))
+	for i := range types {
+		b = appendString(b, names[i])
+		b = appendString(b, types[i])
+		b = append(b, columns[i]...)
+	}
+
+	return b, nil
+}
+
+// splitStructure parses "Path String, Time UInt32" to names and types of columns
+func splitStructure(s string) ([]string, []string) {
+	names := make([]string, 0)
+	types := make([]string, 0)
+
+	depth := 0
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			switch s[i] {
+			case '(':
+				depth++
+				continue
+			case ')':
+				depth--
+				continue
+			case ',':
+				if depth > 0 {
+					continue
+				}
+			default:
+				continue
+			}
+		}
+
+		f := strings.TrimSpace(s[start:i])
+		start = i + 1
+		if f == "" {
+			continue
+		}
+		if j := strings.IndexAny(f, " \t"); j > 0 {
+			names = append(names, f[:j])
+			types = append(types, strings.TrimSpace(f[j+1:]))
+		}
+	}
+
+	return names, types
+}
+
+// nativeFormat writes blocks in output format requested by query
+type nativeFormat interface {
+	block(w *bytes.Buffer, b *nativeBlock)
+	end(w *bytes.Buffer)
+}
+
+func newNativeFormat(name string) (nativeFormat, error) {
+	switch name {
+	case "RowBinary":
+		return &rowBinaryFormat{}, nil
+	case "TabSeparated", "TSV":
+		return &tabSeparatedFormat{}, nil
+	case "JSON":
+		return &jsonFormat{}, nil
+	}
+	return nil, fmt.Errorf("format %s is not supported by native protocol client", name)
+}
+
+type rowBinaryFormat struct{}
+
+func (f *rowBinaryFormat) block(w *bytes.Buffer, b *nativeBlock) {
+	for i := 0; i < b.rows; i++ {
+		for _, c := range b.columns {
+			c.rowBinary(w, i)
+		}
+	}
+}
+
+func (f *rowBinaryFormat) end(w *bytes.Buffer) {}
+
+type tabSeparatedFormat struct{}
+
+func (f *tabSeparatedFormat) block(w *bytes.Buffer, b *nativeBlock) {
+	for i := 0; i < b.rows; i++ {
+		for j, c := range b.columns {
+			if j > 0 {
+				w.WriteByte('\t')
+			}
+			c.text(w, i, false)
+		}
+		w.WriteByte('\n')
+	}
+}
+
+func (f *tabSeparatedFormat) end(w *bytes.Buffer) {}
+
+type jsonFormat struct {
+	names []string
+	rows  int
+}
+
+func (f *jsonFormat) block(w *bytes.Buffer, b *nativeBlock) {
+	if f.names == nil {
+		f.names = b.names
+		w.WriteString("{\n\t\"meta\":\n\t[")
+		for i := range b.names {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString("\n\t\t{\"name\": ")
+			writeJSONString(w, b.names[i])
+			w.WriteString(", \"type\": ")
+			writeJSONString(w, b.types[i])
+			w.WriteByte('}')
+		}
+		w.WriteString("\n\t],\n\n\t\"data\":\n\t[")
+	}
+
+	for i := 0; i < b.rows; i++ {
+		if f.rows > 0 {
+			w.WriteByte(',')
+		}
+		f.rows++
+		w.WriteString("\n\t\t{")
+		for j, c := range b.columns {
+			if j > 0 {
+				w.WriteString(", ")
+			}
+			writeJSONString(w, b.names[j])
+			w.WriteString(": ")
+			c.json(w, i)
+		}
+		w.WriteByte('}')
+	}
+}
+
+func (f *jsonFormat) end(w *bytes.Buffer) {
+	if f.names == nil {
+		f.block(w, &nativeBlock{})
+	}
+	fmt.Fprintf(w, "\n\t],\n\n\t\"rows\": %d\n}\n", f.rows)
+}
@@ -0,0 +1,382 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nativeTestQuery struct {
+	query    string
+	settings map[string]string
+	ext      *nativeBlock
+}
+
+// nativeTestServer answers every query with blocks
+type nativeTestServer struct {
+	ln       net.Listener
+	blocks   [][]byte // columns and rows of response blocks without block info
+	err      string   // exception message instead of blocks
+	accepted int32
+
+	mu      sync.Mutex
+	queries []nativeTestQuery
+}
+
+func newNativeTestServer(t *testing.T) *nativeTestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &nativeTestServer{ln: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *nativeTestServer) dsn(params string) string {
+	return "tcp://" + s.ln.Addr().String() + "/?" + params
+}
+
+func (s *nativeTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	readUvarint := func() uint64 {
+		v, _ := binary.ReadUvarint(r)
+		return v
+	}
+
+	// client hello
+	readUvarint()
+	readString(r)
+	readUvarint()
+	readUvarint()
+	readUvarint()
+	readString(r)
+	readString(r)
+	readString(r)
+
+	hello := appendUvarint(nil, nativeServerHello)
+	hello = appendString(hello, "ClickHouse")
+	hello = appendUvarint(hello, 23)
+	hello = appendUvarint(hello, 8)
+	hello = appendUvarint(hello, 54460)
+	hello = appendString(hello, "UTC")
+	hello = appendString(hello, "test")
+	hello = appendUvarint(hello, 1)
+	conn.Write(hello)
+
+	for {
+		code, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+
+		if code == nativeClientPing {
+			conn.Write(appendUvarint(nil, nativeServerPong))
+			continue
+		}
+
+		q := nativeTestQuery{settings: make(map[string]string)}
+		readString(r) // query id
+		r.ReadByte()
+		readString(r)
+		readString(r)
+		readString(r)
+		r.ReadByte()
+		readString(r)
+		readString(r)
+		readString(r)
+		readUvarint()
+		readUvarint()
+		readUvarint()
+		readString(r)
+		readUvarint()
+		for {
+			name, _ := readString(r)
+			if name == "" {
+				break
+			}
+			readUvarint()
+			q.settings[name], _ = readString(r)
+		}
+		readUvarint() // stage
+		compress := readUvarint() == 1
+		q.query, _ = readString(r)
+
+		var br byteReader = r
+		if compress {
+			br = &compressedReader{r: r}
+		}
+
+		// external tables
+		for {
+			readUvarint()
+			name, _ := readString(r)
+			block, err := readBlock(br, time.UTC)
+			if err != nil {
+				return
+			}
+			if name == "" {
+				break
+			}
+			q.ext = block
+		}
+
+		s.mu.Lock()
+		s.queries = append(s.queries, q)
+		s.mu.Unlock()
+
+		var resp []byte
+		if s.err != "" {
+			resp = appendUvarint(resp, nativeServerException)
+			resp = append(resp, 60, 0, 0, 0)
+			resp = appendString(resp, "DB::Exception")
+			resp = appendString(resp, s.err)
+			resp = appendString(resp, "")
+			resp = append(resp, 0)
+			conn.Write(resp)
+			continue
+		}
+
+		resp = appendUvarint(resp, nativeServerProgress)
+		for i := 0; i < 5; i++ {
+			resp = appendUvarint(resp, 1)
+		}
+		for _, b := range s.blocks {
+			block := append(appendBlockInfo(nil), b...)
+			resp = appendUvarint(resp, nativeServerData)
+			resp = appendString(resp, "")
+			if compress {
+				resp = append(resp, compressBlock(block)...)
+			} else {
+				resp = append(resp, block...)
+			}
+		}
+		resp = appendUvarint(resp, nativeServerProfileInfo)
+		resp = append(resp, 1, 1, 1, 0, 0, 0)
+		resp = appendUvarint(resp, nativeServerEndOfStream)
+		conn.Write(resp)
+	}
+}
+
+func u32(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], x)
+	}
+	return b
+}
+
+func f64(v ...float64) []byte {
+	b := make([]byte, 8*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(x))
+	}
+	return b
+}
+
+func strs(v ...string) []byte {
+	var b []byte
+	for _, s := range v {
+		b = appendString(b, s)
+	}
+	return b
+}
+
+// testBlock builds columns and rows of block from names, types and data of columns
+func testBlock(rows int, columns ...interface{}) []byte {
+	b := appendUvarint(nil, uint64(len(columns)/3))
+	b = appendUvarint(b, uint64(rows))
+	for i := 0; i < len(columns); i += 3 {
+		b = appendString(b, columns[i].(string))
+		b = appendString(b, columns[i+1].(string))
+		b = append(b, columns[i+2].([]byte)...)
+	}
+	return b
+}
+
+func TestNativeRowBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newNativeTestServer(t)
+	defer srv.ln.Close()
+
+	srv.blocks = [][]byte{
+		testBlock(0, "Path", "String", []byte{}, "Time", "UInt32", []byte{}, "Value", "Float64", []byte{}),
+		testBlock(2,
+			"Path", "String", strs("a.b", "a.c"),
+			"Time", "UInt32", u32(10, 20),
+			"Value", "Float64", f64(1.5, 2.5),
+		),
+	}
+
+	for _, compress := range []string{"1", "0"} {
+		ext := NewExternalData("_ext", "Path String", "RowBinary")
+		ext.Write(strs("a.b", "a.c"))
+
+		body, err := ReaderExternal(context.Background(), srv.dsn("max_threads=4&compress="+compress),
+			"SELECT Path, Time, Value FROM graphite WHERE Path IN _ext FORMAT RowBinary", "graphite", ext,
+			Options{Timeout: time.Second, ConnectTimeout: time.Second, Settings: map[string]string{"max_execution_time": "10"}})
+		if !assert.NoError(err) {
+			return
+		}
+
+		var data bytes.Buffer
+		data.ReadFrom(body)
+		body.Close()
+
+		var expected []byte
+		expected = append(expected, strs("a.b")...)
+		expected = append(expected, u32(10)...)
+		expected = append(expected, f64(1.5)...)
+		expected = append(expected, strs("a.c")...)
+		expected = append(expected, u32(20)...)
+		expected = append(expected, f64(2.5)...)
+		assert.Equal(expected, data.Bytes())
+
+		srv.mu.Lock()
+		q := srv.queries[len(srv.queries)-1]
+		srv.mu.Unlock()
+
+		assert.True(strings.HasPrefix(q.query, "SELECT Path, Time, Value"))
+		assert.Equal(map[string]string{"max_threads": "4", "max_execution_time": "10"}, q.settings)
+		if assert.NotNil(q.ext) {
+			assert.Equal(2, q.ext.rows)
+			assert.Equal([]string{"Path"}, q.ext.names)
+			var ext bytes.Buffer
+			(&tabSeparatedFormat{}).block(&ext, q.ext)
+			assert.Equal("a.b\na.c\n", ext.String())
+		}
+	}
+}
+
+func TestNativeFormats(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newNativeTestServer(t)
+	defer srv.ln.Close()
+
+	srv.blocks = [][]byte{
+		testBlock(2,
+			"name", "String", strs("a\tb", "it's"),
+			"age", "UInt32", u32(60, 0),
+			"tags", "Array(String)", append(append(make([]byte, 0), 1, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0), strs("x", "y", "z'")...),
+			"value", "Nullable(Float64)", append([]byte{0, 1}, f64(0.5, 0)...),
+			"date", "Date", []byte{0x7c, 0x44, 0x7d, 0x44},
+		),
+	}
+
+	opts := Options{Timeout: time.Second, ConnectTimeout: time.Second}
+
+	body, err := Query(context.Background(), srv.dsn(""), "SELECT name, age, tags, value, date FROM t", "t", opts)
+	assert.NoError(err)
+	assert.Equal("a\\tb\t60\t['x']\t0.5\t2018-01-01\nit's\t0\t['y','z\\'']\t\\N\t2018-01-02\n", string(body))
+
+	body, err = Query(context.Background(), srv.dsn(""), "SELECT name, age, tags, value, date FROM t FORMAT JSON", "t", opts)
+	assert.NoError(err)
+
+	var resp struct {
+		Data []struct {
+			Name  string   `json:"name"`
+			Age   uint32   `json:"age"`
+			Tags  []string `json:"tags"`
+			Value *float64 `json:"value"`
+			Date  string   `json:"date"`
+		} `json:"data"`
+		Rows int `json:"rows"`
+	}
+	assert.NoError(json.Unmarshal(body, &resp))
+	assert.Equal(2, resp.Rows)
+	if assert.Len(resp.Data, 2) {
+		assert.Equal("it's", resp.Data[1].Name)
+		assert.Equal([]string{"y", "z'"}, resp.Data[1].Tags)
+		assert.Nil(resp.Data[1].Value)
+		assert.Equal(0.5, *resp.Data[0].Value)
+		assert.Equal(uint32(60), resp.Data[0].Age)
+		assert.Equal("2018-01-01", resp.Data[0].Date)
+	}
+
+	// connection is reused
+	assert.Equal(int32(1), atomic.LoadInt32(&srv.accepted))
+
+	_, err = Query(context.Background(), srv.dsn(""), "SELECT 1 FORMAT Pretty", "t", opts)
+	assert.Error(err)
+}
+
+func TestNativeException(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := newNativeTestServer(t)
+	defer srv.ln.Close()
+	srv.err = "Unknown identifier: x"
+
+	_, err := Query(context.Background(), srv.dsn(""), "SELECT x", "t", Options{Timeout: time.Second, ConnectTimeout: time.Second})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Unknown identifier: x")
+	}
+
+	assert.NoError(pingNative(srv.dsn(""), time.Second))
+}
+
+func TestCompressBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	data := []byte(strings.Repeat("carbon.agents.host1.cpu.load ", 1000) + "end")
+	block := compressBlock(data)
+	assert.True(len(block) < len(data)/10)
+
+	r := &compressedReader{r: bytes.NewReader(block)}
+	result := make([]byte, len(data))
+	_, err := r.Read(result)
+	assert.NoError(err)
+	assert.Equal(data, result)
+
+	block[len(block)-1] ^= 1
+	_, err = (&compressedReader{r: bytes.NewReader(block)}).Read(result)
+	assert.Error(err)
+}
+
+func TestCityHash128(t *testing.T) {
+	assert := assert.New(t)
+
+	// vectors of reference CityHash v1.0.2 (cityhash.cpp, checksum of ClickHouse compressed blocks) from
+	// lib/cityhash102/testdata/hashs.txt of github.com/ClickHouse/clickhouse-go v1.4.3
+	tests := []struct {
+		data string
+		hash uint128
+	}{
+		{"I", uint128{0x3a4a285c94f1936e, 0x18115b183f326829}},
+		{"ikMYpqGCRNf8lu", uint128{0x5c28ef61e44ca61c, 0x3cf4e536bb86eb23}},
+		{"TSDGQtM27SmjL0naFMqcQ3ETsYKbDbrBeIj", uint128{0x5a568f460b784901, 0x8194e9a0cefb22fd}},
+		{
+			"f3PTbyzmDIlsXhTmNIxE6I7B8x7UJ7MSmWkSkWLhTGdIhvcYAFVtyg76T7eWbkuu0o3ez9VFmHDdI6k8WGS9GkXaX1xQ28EW" +
+				"QruxrIEyI3L8CnsxUQudXjw4oqN3JJkE3h1P5JhiW1cdne4mRk4q3ps3SK",
+			uint128{0x7f50346fbdf984c8, 0xadeeed6907ad90d7},
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(tt.hash, cityHash128([]byte(tt.data)), "len %d", len(tt.data))
+	}
+}
@@ -0,0 +1,86 @@
+package clickhouse
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TransportOptions of connections pool to every ClickHouse endpoint
+type TransportOptions struct {
+	MaxIdleConnsPerHost int           // 0 - keep-alive disabled, new connection per query
+	IdleConnTimeout     time.Duration // idle connection is closed after timeout
+	KeepAlive           time.Duration // period of TCP keep-alive probes
+}
+
+// DefaultTransportOptions are used until SetTransportOptions called
+var DefaultTransportOptions = TransportOptions{
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     time.Minute,
+	KeepAlive:           30 * time.Second,
+}
+
+type transportKey struct {
+	scheme         string
+	host           string
+	connectTimeout time.Duration
+}
+
+// transportPool keeps one shared http.Transport per endpoint and connect timeout
+type transportPool struct {
+	sync.Mutex
+	opts TransportOptions
+	m    map[transportKey]*http.Transport
+}
+
+var transports = &transportPool{
+	opts: DefaultTransportOptions,
+	m:    make(map[transportKey]*http.Transport),
+}
+
+// SetTransportOptions replaces options of pool. Idle connections of previous transports are closed
+func SetTransportOptions(opts TransportOptions) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	for _, t := range transports.m {
+		t.CloseIdleConnections()
+	}
+
+	transports.opts = opts
+	transports.m = make(map[transportKey]*http.Transport)
+
+	nativeConns.closeIdle()
+}
+
+func (p *transportPool) options() TransportOptions {
+	p.Lock()
+	defer p.Unlock()
+	return p.opts
+}
+
+func (p *transportPool) get(scheme string, host string, connectTimeout time.Duration) *http.Transport {
+	key := transportKey{scheme: scheme, host: host, connectTimeout: connectTimeout}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if t, ok := p.m[key]; ok {
+		return t
+	}
+
+	t := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: p.opts.KeepAlive,
+		}).DialContext,
+		MaxIdleConns:        0, // limited per host only
+		MaxIdleConnsPerHost: p.opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:     p.opts.IdleConnTimeout,
+		DisableKeepAlives:   p.opts.MaxIdleConnsPerHost == 0,
+	}
+
+	p.m[key] = t
+	return t
+}
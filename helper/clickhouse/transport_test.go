@@ -0,0 +1,68 @@
+package clickhouse
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportKeepAlive(t *testing.T) {
+	assert := assert.New(t)
+
+	var connections int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	defer SetTransportOptions(DefaultTransportOptions)
+
+	opts := Options{Timeout: time.Second, ConnectTimeout: time.Second}
+
+	SetTransportOptions(DefaultTransportOptions)
+	for i := 0; i < 3; i++ {
+		body, err := Query(context.Background(), srv.URL, "SELECT 1", "", opts)
+		assert.NoError(err)
+		assert.Equal("ok", string(body))
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&connections))
+
+	// keep-alive disabled
+	SetTransportOptions(TransportOptions{})
+	atomic.StoreInt32(&connections, 0)
+	for i := 0; i < 3; i++ {
+		_, err := Query(context.Background(), srv.URL, "SELECT 1", "", opts)
+		assert.NoError(err)
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&connections))
+}
+
+func TestTransportQueryTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := Query(context.Background(), srv.URL, "SELECT 1", "", Options{Timeout: 50 * time.Millisecond, ConnectTimeout: time.Second})
+	assert.Error(err)
+	assert.True(time.Since(start) < 500*time.Millisecond)
+}
@@ -1,6 +1,13 @@
 package rollup
 
 import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/lomik/graphite-clickhouse/helper/point"
 )
 
@@ -56,3 +63,143 @@ func AggrAnyLast(points []point.Point) (r float64) {
 	}
 	return
 }
+
+func AggrCount(points []point.Point) (r float64) {
+	return float64(len(points))
+}
+
+// AggrLastByTimestamp returns value of the last written point
+func AggrLastByTimestamp(points []point.Point) (r float64) {
+	if len(points) == 0 {
+		return
+	}
+	last := points[0]
+	for _, p := range points[1:] {
+		if p.Timestamp >= last.Timestamp {
+			last = p
+		}
+	}
+	return last.Value
+}
+
+// AggrQuantile returns function of level quantile of points values.
+// Values are interpolated between two nearest points like clickhouse quantile() does
+func AggrQuantile(level float64) func(points []point.Point) float64 {
+	return func(points []point.Point) (r float64) {
+		if len(points) == 0 {
+			return
+		}
+
+		values := make([]float64, len(points))
+		for i, p := range points {
+			values[i] = p.Value
+		}
+		sort.Float64s(values)
+
+		index := level * float64(len(values)-1)
+		lo := int(math.Floor(index))
+		hi := int(math.Ceil(index))
+		return values[lo] + (index-float64(lo))*(values[hi]-values[lo])
+	}
+}
+
+// Aggr is aggregate function of rollup rule
+type Aggr struct {
+	name string
+	f    func(points []point.Point) float64
+	sql  string // clickhouse expression. %[1]s - value column, %[2]s - time column, %[3]s - timestamp column
+	// merge rolls up already aggregated points, e.g. by previous retention or clickhouse. nil - f
+	merge func(points []point.Point) float64
+}
+
+var aggrMap = map[string]*Aggr{
+	"avg":     {"avg", AggrAvg, "avg(%[1]s)", nil},
+	"max":     {"max", AggrMax, "max(%[1]s)", nil},
+	"min":     {"min", AggrMin, "min(%[1]s)", nil},
+	"sum":     {"sum", AggrSum, "sum(%[1]s)", nil},
+	"any":     {"any", AggrAny, "argMin(%[1]s, %[2]s)", nil},
+	"anyLast": {"anyLast", AggrAnyLast, "argMax(%[1]s, %[2]s)", nil},
+	"count":   {"count", AggrCount, "toFloat64(count(%[1]s))", AggrSum},
+	"median":  {"median", AggrQuantile(0.5), "quantile(0.5)(%[1]s)", nil},
+	"argMax":  {"argMax", AggrLastByTimestamp, "argMax(%[1]s, %[3]s)", nil},
+}
+
+// parametric functions like quantile(0.9)
+var aggrParametricMap = map[string]func(params []float64) (*Aggr, error){
+	"quantile": func(params []float64) (*Aggr, error) {
+		if len(params) != 1 || params[0] < 0 || params[0] > 1 {
+			return nil, fmt.Errorf("quantile level must be in range [0, 1]")
+		}
+		level := strconv.FormatFloat(params[0], 'g', -1, 64)
+		return &Aggr{
+			name: "quantile(" + level + ")",
+			f:    AggrQuantile(params[0]),
+			sql:  "quantile(" + level + ")(%[1]s)",
+		}, nil
+	},
+}
+
+var aggrParametricRe = regexp.MustCompile(`^(\w+)\((.*)\)$`)
+
+// parseAggr returns aggregate function by its name from rollup config. Parametric functions are written as "quantile(0.9)"
+func parseAggr(function string) (*Aggr, error) {
+	function = strings.TrimSpace(function)
+
+	if aggr, ok := aggrMap[function]; ok {
+		return aggr, nil
+	}
+
+	m := aggrParametricRe.FindStringSubmatch(function)
+	if m == nil || aggrParametricMap[m[1]] == nil {
+		return nil, fmt.Errorf("unknown function %#v", function)
+	}
+
+	params := make([]float64, 0)
+	for _, p := range strings.Split(m[2], ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad parameter of function %#v: %s", function, err.Error())
+		}
+		params = append(params, v)
+	}
+
+	aggr, err := aggrParametricMap[m[1]](params)
+	if err != nil {
+		return nil, fmt.Errorf("bad function %#v: %s", function, err.Error())
+	}
+
+	return aggr, nil
+}
+
+// Name returns function name from rollup config
+func (ag *Aggr) Name() string {
+	return ag.name
+}
+
+// Do aggregates values of points
+func (ag *Aggr) Do(points []point.Point) float64 {
+	return ag.f(points)
+}
+
+// mergeFunc returns function which rolls up points already aggregated with ag
+func (ag *Aggr) mergeFunc() func(points []point.Point) float64 {
+	if ag.merge != nil {
+		return ag.merge
+	}
+	return ag.f
+}
+
+// SQL returns clickhouse aggregate expression for value column.
+// Points order is defined by time column, write order is defined by timestamp column
+func (ag *Aggr) SQL(valueColumn, timeColumn, timestampColumn string) string {
+	return fmt.Sprintf(ag.sql, valueColumn, timeColumn, timestampColumn)
+}
+
+// GetAggr returns aggregate function by name or nil if function is unknown
+func GetAggr(name string) *Aggr {
+	aggr, err := parseAggr(name)
+	if err != nil {
+		return nil
+	}
+	return aggr
+}
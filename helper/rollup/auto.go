@@ -0,0 +1,220 @@
+package rollup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/zapwriter"
+)
+
+// Rollup holds current rollup rules. Rules loaded from clickhouse are updated in background
+// and replaced atomically, so request should take Rules() once and use them
+type Rollup struct {
+	rules atomic.Value // *Rules
+
+	// source of rules for NewAuto
+	url      string
+	table    string
+	interval time.Duration
+	opts     clickhouse.Options
+
+	// clickhouse without rule_type column in system.graphite_retentions
+	noRuleType bool
+}
+
+// NewStatic returns rollup with constant rules
+func NewStatic(rules *Rules) *Rollup {
+	r := &Rollup{}
+	r.rules.Store(rules)
+	return r
+}
+
+// NewAuto returns rollup with rules of table from system.graphite_retentions of clickhouse.
+// Rules are not loaded until Start
+func NewAuto(url string, table string, interval time.Duration, opts clickhouse.Options) *Rollup {
+	return &Rollup{
+		url:      url,
+		table:    table,
+		interval: interval,
+		opts:     opts,
+	}
+}
+
+// Start loads rules of auto rollup and reloads them every interval until ctx is done.
+// On reload error the previous rules are used. Start of static rollup does nothing
+func (r *Rollup) Start(ctx context.Context) error {
+	if r.url == "" {
+		return nil
+	}
+
+	if err := r.update(ctx); err != nil {
+		return err
+	}
+
+	if r.interval > 0 {
+		go r.updateWorker(ctx)
+	}
+
+	return nil
+}
+
+// Rules returns current rules, nil if auto rollup is not started
+func (r *Rollup) Rules() *Rules {
+	rules, _ := r.rules.Load().(*Rules)
+	return rules
+}
+
+func (r *Rollup) Match(metric string) *Pattern {
+	return r.Rules().Match(metric)
+}
+
+func (r *Rollup) Step(metric string, from uint32) uint32 {
+	return r.Rules().Step(metric, from)
+}
+
+func (r *Rollup) MaxStep(from uint32) uint32 {
+	return r.Rules().MaxStep(from)
+}
+
+func (r *Rollup) Lookup(metric string, fromTimestamp uint32) (uint32, *Pattern) {
+	return r.Rules().Lookup(metric, fromTimestamp)
+}
+
+func (r *Rollup) RollupMetric(metricName string, fromTimestamp uint32, points []point.Point) ([]point.Point, uint32) {
+	return r.Rules().RollupMetric(metricName, fromTimestamp, points)
+}
+
+func (r *Rollup) updateWorker(ctx context.Context) {
+	logger := zapwriter.Logger("rollup").With(zap.String("table", r.table))
+
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if err := r.update(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("rollup rules update failed", zap.Error(err))
+		}
+	}
+}
+
+func (r *Rollup) update(ctx context.Context) error {
+	body, err := clickhouse.Query(ctx, r.url, retentionsQuery(r.table, !r.noRuleType), "system.graphite_retentions", r.opts)
+	if err != nil && !r.noRuleType && strings.Contains(err.Error(), "rule_type") {
+		// older clickhouse has no rule_type column, all its rules are of type "all"
+		r.noRuleType = true
+		body, err = clickhouse.Query(ctx, r.url, retentionsQuery(r.table, false), "system.graphite_retentions", r.opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	rules, err := parseRetentions(body)
+	if err != nil {
+		return fmt.Errorf("rollup rules of table %s: %s", r.table, err.Error())
+	}
+
+	r.rules.Store(rules)
+	return nil
+}
+
+// retentionsQuery returns query of rollup rules of table. Table without database is searched in current database
+func retentionsQuery(table string, withRuleType bool) string {
+	database := "currentDatabase()"
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		database = "'" + clickhouse.Escape(table[:i]) + "'"
+		table = table[i+1:]
+	}
+
+	ruleType := ""
+	if withRuleType {
+		ruleType = ", rule_type"
+	}
+
+	return fmt.Sprintf(
+		`SELECT
+			regexp, function, toUInt32(age) AS age, toUInt32(precision) AS precision, priority, is_default%s
+		FROM system.graphite_retentions
+		ARRAY JOIN Tables AS table
+		WHERE (table.database = %s) AND (table.table = '%s')
+		ORDER BY is_default ASC, priority ASC, regexp ASC, age ASC
+		FORMAT JSON`,
+		ruleType, database, clickhouse.Escape(table),
+	)
+}
+
+type retentionRow struct {
+	RuleType  string `json:"rule_type"`
+	Regexp    string `json:"regexp"`
+	Function  string `json:"function"`
+	Age       uint32 `json:"age"`
+	Precision uint32 `json:"precision"`
+	Priority  uint16 `json:"priority"`
+	IsDefault uint8  `json:"is_default"`
+}
+
+// parseRetentions builds rules from JSON response of retentionsQuery. Every pattern is a sequence of rows
+// with the same priority, rule type, regexp and function ordered by age. Row with zero age and precision is a pattern without retentions
+func parseRetentions(body []byte) (*Rules, error) {
+	var resp struct {
+		Data []retentionRow `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("no rules found in system.graphite_retentions")
+	}
+
+	r := &Rules{Pattern: make([]*Pattern, 0)}
+
+	var last *Pattern
+	var lastRow retentionRow
+
+	for _, row := range resp.Data {
+		if last == nil ||
+			row.IsDefault != lastRow.IsDefault ||
+			row.Priority != lastRow.Priority ||
+			row.RuleType != lastRow.RuleType ||
+			row.Regexp != lastRow.Regexp ||
+			row.Function != lastRow.Function {
+
+			last = &Pattern{RuleType: row.RuleType, Regexp: row.Regexp, Function: row.Function}
+			if last.RuleType == RuleTagList {
+				// regexp of tag_list rule is already built by clickhouse
+				last.RuleType = RuleTagged
+			}
+			if row.IsDefault != 0 {
+				r.Default = last
+			} else {
+				r.Pattern = append(r.Pattern, last)
+			}
+		}
+		lastRow = row
+
+		if row.Age != 0 || row.Precision != 0 {
+			last.Retention = append(last.Retention, &Retention{Age: row.Age, Precision: row.Precision})
+		}
+	}
+
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
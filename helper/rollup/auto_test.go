@@ -0,0 +1,179 @@
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+)
+
+const retentionsResponse = `{
+	"meta": [],
+	"data": [
+		{"rule_type": "all", "regexp": "^sum\\.", "function": "sum", "age": 0, "precision": 10, "priority": 0, "is_default": 0},
+		{"rule_type": "all", "regexp": "^sum\\.", "function": "sum", "age": 86400, "precision": 60, "priority": 0, "is_default": 0},
+		{"rule_type": "all", "regexp": "\\.max$", "function": "max", "age": 0, "precision": 0, "priority": 1, "is_default": 0},
+		{"rule_type": "all", "regexp": "", "function": "avg", "age": 0, "precision": %d, "priority": 65535, "is_default": 1}
+	],
+	"rows": 4
+}`
+
+func TestParseRetentions(t *testing.T) {
+	r, err := parseRetentions([]byte(fmt.Sprintf(retentionsResponse, 60)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Pattern) != 2 || len(r.Pattern[0].Retention) != 2 || r.Pattern[0].Retention[1].Precision != 60 {
+		t.Fatalf("unexpected patterns %#v", r.Pattern)
+	}
+
+	if len(r.Pattern[1].Retention) != 0 || r.Pattern[1].Function != "max" {
+		t.Fatalf("unexpected function only pattern %#v", r.Pattern[1])
+	}
+
+	if rule := r.Match("a.max"); rule.Aggr().Name() != "max" || rule.Retention[0].Precision != 60 {
+		t.Fatalf("unexpected rule for a.max: %#v", rule)
+	}
+
+	if _, err := parseRetentions([]byte(`{"data": []}`)); err == nil {
+		t.Fatal("expected error for empty rules")
+	}
+}
+
+func TestParseRetentionsRuleType(t *testing.T) {
+	r, err := parseRetentions([]byte(`{"data": [
+		{"rule_type": "tagged", "regexp": "^cpu", "function": "max", "age": 0, "precision": 10, "priority": 0, "is_default": 0},
+		{"rule_type": "plain", "regexp": "^cpu", "function": "max", "age": 0, "precision": 20, "priority": 0, "is_default": 0},
+		{"rule_type": "tag_list", "regexp": "[\\?&]dc=east(&.*)?$", "function": "sum", "age": 0, "precision": 30, "priority": 1, "is_default": 0},
+		{"rule_type": "all", "regexp": "", "function": "avg", "age": 0, "precision": 60, "priority": 65535, "is_default": 1}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Pattern) != 3 {
+		t.Fatalf("unexpected patterns %#v", r.Pattern)
+	}
+
+	now := uint32(time.Now().Unix())
+	if step := r.Step("cpu?host=a", now); step != 10 {
+		t.Fatalf("expected step 10 of tagged rule, actual %d", step)
+	}
+	// tagged rule with the same regexp is not applied to plain metric
+	if step := r.Step("cpu.load", now); step != 20 {
+		t.Fatalf("expected step 20 of plain rule, actual %d", step)
+	}
+	if step := r.Step("mem?dc=east", now); step != 30 {
+		t.Fatalf("expected step 30 of tag_list rule, actual %d", step)
+	}
+	if step := r.Step("mem.dc=east", now); step != 60 {
+		t.Fatalf("expected default step 60, actual %d", step)
+	}
+}
+
+func TestRetentionsQuery(t *testing.T) {
+	q := retentionsQuery("graphite", true)
+	if !strings.Contains(q, "(table.database = currentDatabase()) AND (table.table = 'graphite')") ||
+		!strings.Contains(q, "is_default, rule_type") {
+		t.Fatalf("unexpected query %s", q)
+	}
+
+	q = retentionsQuery("db.graphite", false)
+	if !strings.Contains(q, "(table.database = 'db') AND (table.table = 'graphite')") ||
+		strings.Contains(q, "rule_type") {
+		t.Fatalf("unexpected query %s", q)
+	}
+}
+
+func TestNewAuto(t *testing.T) {
+	var precision int32 = 60
+	var requests int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		p := atomic.LoadInt32(&precision)
+		if p == 0 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, retentionsResponse, p)
+	}))
+	defer srv.Close()
+
+	r := NewAuto(srv.URL, "graphite", 10*time.Millisecond, clickhouse.Options{Timeout: time.Second, ConnectTimeout: time.Second})
+	if r.Rules() != nil || atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("rules are loaded before start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := r.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := r.Rules()
+	if step := r.Step("a.b", uint32(time.Now().Unix())); step != 60 {
+		t.Fatalf("expected step 60, actual %d", step)
+	}
+
+	atomic.StoreInt32(&precision, 20)
+	for i := 0; i < 100 && r.Step("a.b", uint32(time.Now().Unix())) != 20; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if step := r.Step("a.b", uint32(time.Now().Unix())); step != 20 {
+		t.Fatalf("expected step 20 after update, actual %d", step)
+	}
+
+	// taken rules are not changed
+	if step := rules.Step("a.b", uint32(time.Now().Unix())); step != 60 {
+		t.Fatalf("expected step 60 of old rules, actual %d", step)
+	}
+
+	// previous rules are used on error
+	atomic.StoreInt32(&precision, 0)
+	time.Sleep(50 * time.Millisecond)
+	if step := r.Step("a.b", uint32(time.Now().Unix())); step != 20 {
+		t.Fatalf("expected step 20 after failed update, actual %d", step)
+	}
+
+	// updates are stopped with context
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	n := atomic.LoadInt32(&requests)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&requests) != n {
+		t.Fatal("rules are updated after cancel")
+	}
+}
+
+func TestAutoWithoutRuleType(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Query().Get("query"), "rule_type") {
+			http.Error(w, "Code: 47, e.displayText() = DB::Exception: Missing columns: 'rule_type'", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"data": [{"regexp": "", "function": "avg", "age": 0, "precision": 30, "priority": 65535, "is_default": 1}]}`)
+	}))
+	defer srv.Close()
+
+	r := NewAuto(srv.URL, "graphite", 0, clickhouse.Options{Timeout: time.Second, ConnectTimeout: time.Second})
+	if err := r.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if step := r.Step("a.b", uint32(time.Now().Unix())); step != 30 {
+		t.Fatalf("expected step 30, actual %d", step)
+	}
+
+	if err := NewStatic(r.Rules()).Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package rollup
+
+import "sync"
+
+// matchCacheSize is max number of metrics in match cache of rules
+const matchCacheSize = 100000
+
+// matchCache stores matched pattern of metric. Cache is reset when it is full
+type matchCache struct {
+	sync.RWMutex
+	size int
+	m    map[string]*Pattern
+}
+
+func newMatchCache(size int) *matchCache {
+	return &matchCache{
+		size: size,
+		m:    make(map[string]*Pattern),
+	}
+}
+
+func (c *matchCache) get(metric string) (*Pattern, bool) {
+	c.RLock()
+	p, ok := c.m[metric]
+	c.RUnlock()
+	return p, ok
+}
+
+func (c *matchCache) set(metric string, p *Pattern) {
+	c.Lock()
+	if len(c.m) >= c.size {
+		c.m = make(map[string]*Pattern)
+	}
+	c.m[metric] = p
+	c.Unlock()
+}
@@ -0,0 +1,236 @@
+package rollup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+storage-schemas.conf:
+
+[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[default]
+pattern = .*
+retentions = 10s:7d,1m:30d,10m:1y
+
+storage-aggregation.conf:
+
+[max]
+pattern = \.max$
+xFilesFactor = 0.1
+aggregationMethod = max
+*/
+
+// iniSection is section of carbon config file
+type iniSection struct {
+	name   string
+	values map[string]string
+}
+
+// parseINI returns sections of carbon config in order of definition
+func parseINI(body []byte) ([]*iniSection, error) {
+	sections := make([]*iniSection, 0)
+	var current *iniSection
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			current = &iniSection{
+				name:   strings.TrimSpace(line[1 : len(line)-1]),
+				values: make(map[string]string),
+			}
+			sections = append(sections, current)
+			continue
+		}
+
+		i := strings.IndexByte(line, '=')
+		if i < 0 || current == nil {
+			return nil, fmt.Errorf("line %d: can't parse %#v", n, line)
+		}
+
+		current.values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+var carbonUnits = []struct {
+	name  string
+	value uint32
+}{
+	{"seconds", 1},
+	{"minutes", 60},
+	{"hours", 3600},
+	{"days", 86400},
+	{"weeks", 86400 * 7},
+	{"years", 86400 * 365},
+}
+
+// parseCarbonTime parses "10", "10s", "1min", "7d" to seconds like whisper does. Unit is any prefix of unit name
+func parseCarbonTime(s string) (uint32, bool, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if i == 0 {
+		return 0, false, fmt.Errorf("bad time %#v", s)
+	}
+
+	if i < 0 {
+		v, err := strconv.ParseUint(s, 10, 32)
+		return uint32(v), false, err
+	}
+
+	v, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil {
+		return 0, false, err
+	}
+
+	unit := s[i:]
+	for _, u := range carbonUnits {
+		if strings.HasPrefix(u.name, unit) {
+			return uint32(v) * u.value, true, nil
+		}
+	}
+
+	return 0, false, fmt.Errorf("bad time unit %#v", s)
+}
+
+// ParseCarbonRetentions parses whisper retentions "10s:7d,1m:30d". Archive is kept as long as
+// its retention, so age of every next archive is the retention of the previous one
+func ParseCarbonRetentions(s string) ([]*Retention, error) {
+	result := make([]*Retention, 0)
+	var age uint32
+
+	for _, archive := range strings.Split(s, ",") {
+		archive = strings.TrimSpace(archive)
+		parts := strings.Split(archive, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad retention %#v", archive)
+		}
+
+		precision, _, err := parseCarbonTime(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if precision == 0 {
+			return nil, fmt.Errorf("bad retention %#v: zero precision", archive)
+		}
+
+		retention, hasUnit, err := parseCarbonTime(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if !hasUnit {
+			// number of points
+			retention *= precision
+		}
+
+		result = append(result, &Retention{Age: age, Precision: precision})
+		age = retention
+	}
+
+	return result, nil
+}
+
+// carbonFunctions maps whisper aggregationMethod to rollup function
+var carbonFunctions = map[string]string{
+	"average": "avg",
+	"avg":     "avg",
+	"sum":     "sum",
+	"min":     "min",
+	"max":     "max",
+	"last":    "anyLast",
+	"first":   "any",
+}
+
+// ParseCarbon returns rules from carbon storage-schemas.conf and optional storage-aggregation.conf.
+// Schemas and aggregations are converted to patterns with retentions only and functions only,
+// so each metric gets the first matched schema and the first matched aggregation as in carbon.
+// Default rule has retentions of the last schema and carbon default function average with xFilesFactor 0.5
+func ParseCarbon(schemas []byte, aggregation []byte) (*Rollup, error) {
+	rules, err := parseCarbonRules(schemas, aggregation)
+	if err != nil {
+		return nil, err
+	}
+	return NewStatic(rules), nil
+}
+
+func parseCarbonRules(schemas []byte, aggregation []byte) (*Rules, error) {
+	r := &Rules{
+		Pattern: make([]*Pattern, 0),
+		Default: &Pattern{
+			Function:     "avg",
+			XFilesFactor: 0.5,
+		},
+	}
+
+	aggregationSections, err := parseINI(aggregation)
+	if err != nil {
+		return nil, fmt.Errorf("storage-aggregation: %s", err.Error())
+	}
+
+	for _, s := range aggregationSections {
+		p := &Pattern{
+			Regexp:       s.values["pattern"],
+			Function:     "avg",
+			XFilesFactor: 0.5,
+		}
+
+		if method, ok := s.values["aggregationMethod"]; ok {
+			if p.Function, ok = carbonFunctions[method]; !ok {
+				return nil, fmt.Errorf("storage-aggregation [%s]: unsupported aggregationMethod %#v", s.name, method)
+			}
+		}
+
+		if xff, ok := s.values["xFilesFactor"]; ok {
+			if p.XFilesFactor, err = strconv.ParseFloat(xff, 64); err != nil {
+				return nil, fmt.Errorf("storage-aggregation [%s]: bad xFilesFactor %#v", s.name, xff)
+			}
+		}
+
+		if p.Regexp == "" {
+			return nil, fmt.Errorf("storage-aggregation [%s]: pattern not set", s.name)
+		}
+
+		r.Pattern = append(r.Pattern, p)
+	}
+
+	schemaSections, err := parseINI(schemas)
+	if err != nil {
+		return nil, fmt.Errorf("storage-schemas: %s", err.Error())
+	}
+
+	for _, s := range schemaSections {
+		p := &Pattern{Regexp: s.values["pattern"]}
+
+		if p.Retention, err = ParseCarbonRetentions(s.values["retentions"]); err != nil {
+			return nil, fmt.Errorf("storage-schemas [%s]: %s", s.name, err.Error())
+		}
+
+		if p.Regexp == "" {
+			return nil, fmt.Errorf("storage-schemas [%s]: pattern not set", s.name)
+		}
+
+		r.Pattern = append(r.Pattern, p)
+		r.Default.Retention = p.Retention
+	}
+
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
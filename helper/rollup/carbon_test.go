@@ -0,0 +1,92 @@
+package rollup
+
+import "testing"
+
+func TestParseCarbonRetentions(t *testing.T) {
+	table := []struct {
+		retentions string
+		expected   []Retention
+	}{
+		{"60:90d", []Retention{{0, 60}}},
+		{"10s:7d,1m:30d,10m:1y", []Retention{{0, 10}, {7 * 86400, 60}, {30 * 86400, 600}}},
+		{"1min:1440, 1h:2w", []Retention{{0, 60}, {86400, 3600}}},
+	}
+
+	for _, test := range table {
+		r, err := ParseCarbonRetentions(test.retentions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r) != len(test.expected) {
+			t.Fatalf("%#v: unexpected retentions %#v", test.retentions, r)
+		}
+		for i := range r {
+			if *r[i] != test.expected[i] {
+				t.Fatalf("%#v: unexpected retention %d: %#v", test.retentions, i, r[i])
+			}
+		}
+	}
+
+	for _, bad := range []string{"", "60", "0:1d", "1x:1d", "s:1d"} {
+		if _, err := ParseCarbonRetentions(bad); err == nil {
+			t.Fatalf("%#v: error expected", bad)
+		}
+	}
+}
+
+func TestParseCarbon(t *testing.T) {
+	schemas := `
+# carbon metrics
+[carbon]
+pattern = ^carbon\.
+retentions = 60:90d
+
+[default]
+pattern = .*
+retentions = 10s:7d,1m:30d
+`
+	aggregation := `
+[max]
+pattern = \.max$
+xFilesFactor = 0.1
+aggregationMethod = max
+
+[count]
+pattern = \.count$
+aggregationMethod = sum
+`
+
+	r, err := ParseCarbon([]byte(schemas), []byte(aggregation))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		metric       string
+		function     string
+		xFilesFactor float64
+		precision    uint32
+	}{
+		{"carbon.agents.max", "max", 0.1, 60},
+		{"carbon.agents.count", "sum", 0.5, 60},
+		{"carbon.agents.avg", "avg", 0.5, 60},
+		{"hosts.cpu.max", "max", 0.1, 10},
+		{"hosts.cpu", "avg", 0.5, 10},
+	}
+
+	for _, test := range table {
+		p := r.Match(test.metric)
+		if p.Aggr().Name() != test.function || p.XFilesFactor != test.xFilesFactor || p.Retention[0].Precision != test.precision {
+			t.Fatalf("%s: unexpected rule %#v", test.metric, p)
+		}
+	}
+
+	// without storage-aggregation.conf
+	if _, err := ParseCarbon([]byte(schemas), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseCarbon([]byte(schemas), []byte("[bad]\npattern = .*\naggregationMethod = avg_zero\n")); err == nil {
+		t.Fatal("error expected")
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/xml"
 	"fmt"
 	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/lomik/graphite-clickhouse/helper/point"
@@ -14,6 +17,7 @@ import (
  	<pattern>
  		<regexp>click_cost</regexp>
  		<function>any</function>
+ 		<xFilesFactor>0.5</xFilesFactor>
  		<retention>
  			<age>0</age>
  			<precision>3600</precision>
@@ -46,52 +50,131 @@ type Retention struct {
 	Precision uint32 `xml:"precision"`
 }
 
+// Rule types of clickhouse graphite_rollup
+const (
+	RuleAll     = "all"
+	RulePlain   = "plain"
+	RuleTagged  = "tagged"
+	RuleTagList = "tag_list"
+)
+
 type Pattern struct {
-	Regexp    string                      `xml:"regexp"`
-	Function  string                      `xml:"function"`
-	Retention []*Retention                `xml:"retention"`
-	aggr      func([]point.Point) float64 `xml:"-"`
-	re        *regexp.Regexp              `xml:"-"`
+	RuleType     string         `xml:"rule_type"`
+	Regexp       string         `xml:"regexp"`
+	Function     string         `xml:"function"`
+	XFilesFactor float64        `xml:"xFilesFactor"` // min ratio of known points in rolled up interval
+	Retention    []*Retention   `xml:"retention"`
+	aggr         *Aggr          `xml:"-"`
+	re           *regexp.Regexp `xml:"-"`
+	prefix       string         // literal prefix of anchored regexp, checked before regexp
 }
 
-type Rollup struct {
+// Rules is parsed set of rollup rules
+type Rules struct {
 	Pattern []*Pattern `xml:"pattern"`
 	Default *Pattern   `xml:"default"`
+	typed   bool       // rule_type of some pattern is not "all"
+	plain   []*Pattern // patterns for metrics without tags if typed
+	tagged  []*Pattern // patterns for tagged metrics if typed
+	cache   *matchCache
 }
 
 type ClickhouseRollup struct {
-	Rollup Rollup `xml:"graphite_rollup"`
+	Rules Rules `xml:"graphite_rollup"`
+}
+
+// buildTaggedRegexp converts tag_list rule "name;tag1=value1;tag2=value2" to regexp for tagged metric
+// "name?tag1=value1&tag2=value2" the same way as clickhouse does. Tags of rule are sorted, name is optional
+func buildTaggedRegexp(tagList string) string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(tagList, ";") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) == 0 {
+		return ""
+	}
+
+	var re string
+	if !strings.Contains(tags[0], "=") {
+		if len(tags) == 1 {
+			// only name
+			return "^" + tags[0] + "\\?"
+		}
+		re = "^" + tags[0] + "\\?(.*&)?"
+		tags = tags[1:]
+	} else {
+		re = "[\\?&]"
+	}
+
+	sort.Strings(tags)
+
+	return re + strings.Join(tags, "&(.*&)?") + "(&.*)?$"
+}
+
+// literalPrefix returns literal text following ^ of regexp which every matched metric starts with, e.g. "a.b." of `^a\.b\..*`.
+// regexp.LiteralPrefix is not used: it is empty unless the whole regexp is literal
+func literalPrefix(re string) string {
+	if !strings.HasPrefix(re, "^") {
+		return ""
+	}
+
+	parsed, err := syntax.Parse(re, syntax.Perl)
+	if err != nil || parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 || parsed.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+
+	lit := parsed.Sub[1]
+	if lit.Op != syntax.OpLiteral || lit.Flags&syntax.FoldCase != 0 {
+		return ""
+	}
+
+	return string(lit.Rune)
 }
 
 func (rr *Pattern) compile(hasRegexp bool) error {
 	var err error
+
+	switch rr.RuleType {
+	case "":
+		rr.RuleType = RuleAll
+	case RuleAll, RulePlain, RuleTagged:
+	case RuleTagList:
+		rr.Regexp = buildTaggedRegexp(rr.Regexp)
+	default:
+		return fmt.Errorf("unknown rule_type %#v", rr.RuleType)
+	}
+
 	if hasRegexp {
 		rr.re, err = regexp.Compile(rr.Regexp)
 		if err != nil {
 			return err
 		}
+
+		rr.prefix = literalPrefix(rr.Regexp)
 	}
 
-	aggrMap := map[string](func([]point.Point) float64){
-		"avg":     AggrAvg,
-		"max":     AggrMax,
-		"min":     AggrMin,
-		"sum":     AggrSum,
-		"any":     AggrAny,
-		"anyLast": AggrAnyLast,
+	if rr.Function == "" && len(rr.Retention) == 0 {
+		return fmt.Errorf("pattern %#v has neither function nor retention", rr.Regexp)
 	}
 
-	var exists bool
-	rr.aggr, exists = aggrMap[rr.Function]
+	if rr.Function != "" {
+		rr.aggr, err = parseAggr(rr.Function)
+		if err != nil {
+			return err
+		}
+	}
 
-	if !exists {
-		return fmt.Errorf("unknown function %#v", rr.Function)
+	if rr.XFilesFactor < 0 || rr.XFilesFactor > 1 {
+		return fmt.Errorf("xFilesFactor %v is out of range [0, 1]", rr.XFilesFactor)
 	}
 
 	return nil
 }
 
-func (r *Rollup) compile() error {
+func (r *Rules) compile() error {
 	if r.Pattern == nil {
 		r.Pattern = make([]*Pattern, 0)
 	}
@@ -104,17 +187,48 @@ func (r *Rollup) compile() error {
 		return err
 	}
 
+	if r.Default.aggr == nil || len(r.Default.Retention) == 0 {
+		return fmt.Errorf("default rollup rule must have function and retention")
+	}
+
+	r.typed = false
+	r.plain = make([]*Pattern, 0)
+	r.tagged = make([]*Pattern, 0)
+
 	for _, rr := range r.Pattern {
 		if err := rr.compile(true); err != nil {
 			return err
 		}
+
+		if rr.RuleType != RuleAll {
+			r.typed = true
+		}
+
+		if rr.RuleType == RuleAll || rr.RuleType == RulePlain {
+			r.plain = append(r.plain, rr)
+		}
+
+		if rr.RuleType != RulePlain {
+			r.tagged = append(r.tagged, rr)
+		}
 	}
 
+	r.cache = newMatchCache(matchCacheSize)
+
 	return nil
 }
 
+// ParseXML returns static rollup rules from graphite_rollup xml
 func ParseXML(body []byte) (*Rollup, error) {
-	r := &Rollup{}
+	rules, err := parseXMLRules(body)
+	if err != nil {
+		return nil, err
+	}
+	return NewStatic(rules), nil
+}
+
+func parseXMLRules(body []byte) (*Rules, error) {
+	r := &Rules{}
 	err := xml.Unmarshal(body, r)
 	if err != nil {
 		return nil, err
@@ -127,7 +241,7 @@ func ParseXML(body []byte) (*Rollup, error) {
 		if err != nil {
 			return nil, err
 		}
-		r = &y.Rollup
+		r = &y.Rules
 	}
 
 	err = r.compile()
@@ -138,30 +252,142 @@ func ParseXML(body []byte) (*Rollup, error) {
 	return r, nil
 }
 
-// Match returns rollup rules for metric
-func (r *Rollup) Match(metric string) *Pattern {
-	for _, rr := range r.Pattern {
-		if rr.re.MatchString(metric) {
+// hasFunction and hasRetention are parts of rule defined by pattern
+func (rr *Pattern) hasFunction() bool {
+	return rr.aggr != nil
+}
+
+func (rr *Pattern) hasRetention() bool {
+	return len(rr.Retention) > 0
+}
+
+// merge returns rule with function of aggrPattern and retentions of retentionPattern
+func merge(aggrPattern *Pattern, retentionPattern *Pattern) *Pattern {
+	if aggrPattern == retentionPattern {
+		return aggrPattern
+	}
+
+	return &Pattern{
+		RuleType:     aggrPattern.RuleType,
+		Regexp:       aggrPattern.Regexp,
+		Function:     aggrPattern.Function,
+		XFilesFactor: aggrPattern.XFilesFactor,
+		Retention:    retentionPattern.Retention,
+		aggr:         aggrPattern.aggr,
+		re:           aggrPattern.re,
+		prefix:       aggrPattern.prefix,
+	}
+}
+
+// isTagged returns true for tagged metric name in clickhouse format "name?tag1=value1&tag2=value2"
+func isTagged(metric string) bool {
+	return strings.IndexByte(metric, '?') >= 0
+}
+
+// Match returns rollup rules for metric. Rules are selected as clickhouse GraphiteMergeTree does:
+// pattern with both function and retention is used as is, otherwise function and retentions are taken
+// from the first matched patterns which define them. Default rule is matched last.
+// If some patterns have rule_type, plain patterns are used for metrics without tags and tagged ones for tagged metrics.
+// Result is cached until rules are replaced
+func (r *Rules) Match(metric string) *Pattern {
+	if r.cache == nil {
+		return r.match(metric)
+	}
+
+	if p, ok := r.cache.get(metric); ok {
+		return p
+	}
+
+	p := r.match(metric)
+	r.cache.set(metric, p)
+	return p
+}
+
+func (r *Rules) match(metric string) *Pattern {
+	patterns := r.Pattern
+	if r.typed {
+		if isTagged(metric) {
+			patterns = r.tagged
+		} else {
+			patterns = r.plain
+		}
+	}
+
+	var first *Pattern // the first matched pattern with function or retention only
+
+	for _, rr := range patterns {
+		if rr.prefix != "" && !strings.HasPrefix(metric, rr.prefix) {
+			continue
+		}
+
+		if !rr.re.MatchString(metric) {
+			continue
+		}
+
+		if rr.hasFunction() && rr.hasRetention() {
 			return rr
 		}
+
+		if first == nil {
+			first = rr
+			continue
+		}
+
+		if first.hasFunction() && rr.hasRetention() {
+			return merge(first, rr)
+		}
+
+		if first.hasRetention() && rr.hasFunction() {
+			return merge(rr, first)
+		}
+	}
+
+	if first == nil {
+		return r.Default
 	}
 
-	return r.Default
+	if first.hasFunction() {
+		return merge(first, r.Default)
+	}
+
+	return merge(r.Default, first)
+}
+
+func (r *Rules) Step(metric string, from uint32) uint32 {
+	return retentionStep(r.Match(metric).Retention, from, uint32(time.Now().Unix()))
 }
 
-func (r *Rollup) Step(metric string, from uint32) uint32 {
-	pattern := r.Match(metric)
+// retentionStep returns precision of retention for points since from
+func retentionStep(retention []*Retention, from uint32, now uint32) uint32 {
+	for i := range retention {
+		if i == len(retention)-1 || from+retention[i+1].Age > now {
+			return retention[i].Precision
+		}
+	}
+	return retention[len(retention)-1].Precision
+}
+
+// MaxStep returns the coarsest precision of points since from which any rule can produce.
+// Useful when metrics are not known yet, e.g. targets are not resolved
+func (r *Rules) MaxStep(from uint32) uint32 {
 	now := uint32(time.Now().Unix())
 
-	for i := range pattern.Retention {
-		if i == len(pattern.Retention)-1 || from+pattern.Retention[i+1].Age > now {
-			return pattern.Retention[i].Precision
+	step := retentionStep(r.Default.Retention, from, now)
+	for _, p := range r.Pattern {
+		if len(p.Retention) == 0 {
+			continue
+		}
+		if s := retentionStep(p.Retention, from, now); s > step {
+			step = s
 		}
 	}
-	return pattern.Retention[len(pattern.Retention)-1].Precision
+
+	return step
 }
 
-func doMetricPrecision(points []point.Point, precision uint32, aggr func([]point.Point) float64) []point.Point {
+// doMetricPrecision rolls up points to precision with aggr function.
+// Intervals with ratio of points count to expected count of interval started at t less than xFilesFactor are dropped
+func doMetricPrecision(points []point.Point, precision uint32, aggr func([]point.Point) float64, expected func(t uint32) float64, xFilesFactor float64) []point.Point {
 	l := len(points)
 	var i, n int
 	// i - current position of iterator
@@ -171,6 +397,15 @@ func doMetricPrecision(points []point.Point, precision uint32, aggr func([]point
 		return points
 	}
 
+	rollupInterval := func() {
+		if xFilesFactor > 0 && float64(i-n)/expected(points[n].Time) < xFilesFactor {
+			points[n].MetricID = 0
+			return
+		}
+		// single point is aggregated too, e.g. count of it is 1
+		points[n].Value = aggr(points[n:i])
+	}
+
 	// set first point time
 	t := points[0].Time
 	t = t - (t % precision)
@@ -184,22 +419,23 @@ func doMetricPrecision(points []point.Point, precision uint32, aggr func([]point
 		if points[n].Time == t {
 			points[i].MetricID = 0
 		} else {
-			if i > n+1 {
-				points[n].Value = aggr(points[n:i])
-			}
+			rollupInterval()
 			n = i
 		}
 	}
-	if i > n+1 {
-		points[n].Value = aggr(points[n:i])
-	}
+	rollupInterval()
 
 	return point.CleanUp(points)
 }
 
 // RollupMetric rolling up list of points of ONE metric sorted by key "time"
 // returns (new points slice, precision)
-func (r *Rollup) RollupMetric(metricName string, fromTimestamp uint32, points []point.Point) ([]point.Point, uint32) {
+func (r *Rules) RollupMetric(metricName string, fromTimestamp uint32, points []point.Point) ([]point.Point, uint32) {
+	return r.RollupMetricBy(metricName, fromTimestamp, points, nil)
+}
+
+// RollupMetricBy is RollupMetric with aggr function instead of rule function. nil aggr - function of rule
+func (r *Rules) RollupMetricBy(metricName string, fromTimestamp uint32, points []point.Point, aggr *Aggr) ([]point.Point, uint32) {
 	// pp.Println(points)
 
 	l := len(points)
@@ -211,15 +447,118 @@ func (r *Rollup) RollupMetric(metricName string, fromTimestamp uint32, points []
 	rule := r.Match(metricName)
 	precision := uint32(1)
 
-	for _, retention := range rule.Retention {
+	if aggr == nil {
+		aggr = rule.aggr
+	}
+
+	for i, retention := range rule.Retention {
 		if fromTimestamp+retention.Age > now && retention.Age != 0 {
 			break
 		}
 
-		points = doMetricPrecision(points, retention.Precision, rule.aggr)
+		// points of the first retention are stored points, xFilesFactor is checked for next ones
+		// and they roll up already aggregated points
+		f := aggr.f
+		var xFilesFactor float64
+		if i > 0 {
+			f = aggr.mergeFunc()
+			xFilesFactor = rule.XFilesFactor
+		}
+
+		prevPrecision := precision
+		points = doMetricPrecision(points, retention.Precision, f, func(t uint32) float64 {
+			return rule.pointsPerInterval(retention.Precision, prevPrecision, t, now)
+		}, xFilesFactor)
 		precision = retention.Precision
 	}
 
 	// pp.Println(points)
 	return points, precision
 }
+
+// RollupPoints rolling up list of points of ONE metric sorted by key "time" from step to precision with aggregate function.
+// Points are already rolled up to step, so they are aggregated like next retention (e.g. count sums counts).
+// Intervals with ratio of known points less than xFilesFactor are dropped
+func RollupPoints(points []point.Point, step uint32, precision uint32, aggr *Aggr, xFilesFactor float64) []point.Point {
+	expected := float64(precision) / float64(step)
+	return doMetricPrecision(points, precision, aggr.mergeFunc(), func(uint32) float64 { return expected }, xFilesFactor)
+}
+
+// Aggr returns aggregate function of pattern
+func (rr *Pattern) Aggr() *Aggr {
+	return rr.aggr
+}
+
+// pointsPerInterval returns expected number of points with step in interval of precision started at t.
+// GraphiteMergeTree merges points older than age of retention to its precision, so points of
+// interval are counted with the precision they are stored at if it is coarser than step
+func (rr *Pattern) pointsPerInterval(precision uint32, step uint32, t uint32, now uint32) float64 {
+	if len(rr.Retention) > 0 {
+		if stored := retentionStep(rr.Retention, t, now); stored > step {
+			step = stored
+		}
+	}
+	if step == 0 || step >= precision {
+		return 1
+	}
+	return float64(precision) / float64(step)
+}
+
+// PointsPerIntervalSQL returns clickhouse expression of expected number of stored points
+// in interval of precision started at timeColumn. Points older than age of retention are stored with its precision
+func (rr *Pattern) PointsPerIntervalSQL(precision uint32, timeColumn string) string {
+	if len(rr.Retention) == 0 {
+		return "1"
+	}
+
+	now := uint32(time.Now().Unix())
+	first := rr.pointsPerInterval(precision, 0, now, now)
+
+	// conditions of older retentions go first
+	expr := ""
+	same := true
+	for i := len(rr.Retention) - 1; i > 0; i-- {
+		age := rr.Retention[i].Age
+		if age == 0 || age > now {
+			continue
+		}
+		expected := rr.pointsPerInterval(precision, rr.Retention[i].Precision, now, now)
+		if expected != first {
+			same = false
+		}
+		expr += fmt.Sprintf("%s < %d, %g, ", timeColumn, now-age, expected)
+	}
+
+	if same {
+		return fmt.Sprintf("%g", first)
+	}
+	return fmt.Sprintf("multiIf(%s%g)", expr, first)
+}
+
+// Lookup returns precision and rollup rule for metric in time range started from fromTimestamp
+func (r *Rules) Lookup(metric string, fromTimestamp uint32) (uint32, *Pattern) {
+	return r.Step(metric, fromTimestamp), r.Match(metric)
+}
+
+// RollupAggregated rolling up list of points of ONE metric sorted by key "time"
+// which already aggregated by clickhouse with Lookup(metricName, fromTimestamp) precision.
+// Only intervals with several points (e.g. extra points from carbonlink) are aggregated again.
+// returns (new points slice, precision)
+func (r *Rules) RollupAggregated(metricName string, fromTimestamp uint32, points []point.Point) ([]point.Point, uint32) {
+	return r.RollupAggregatedBy(metricName, fromTimestamp, points, nil)
+}
+
+// RollupAggregatedBy is RollupAggregated with aggr function instead of rule function. nil aggr - function of rule
+func (r *Rules) RollupAggregatedBy(metricName string, fromTimestamp uint32, points []point.Point, aggr *Aggr) ([]point.Point, uint32) {
+	precision, rule := r.Lookup(metricName, fromTimestamp)
+
+	if len(points) == 0 {
+		return points, precision
+	}
+
+	if aggr == nil {
+		aggr = rule.aggr
+	}
+
+	return doMetricPrecision(points, precision, aggr.mergeFunc(), nil, 0), precision
+}
@@ -2,6 +2,8 @@ package rollup
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 	"time"
 
@@ -46,11 +48,11 @@ func TestParseXML(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if r.Pattern[0].Retention[1].Age != 86400 {
+	if r.Rules().Pattern[0].Retention[1].Age != 86400 {
 		t.FailNow()
 	}
 
-	if r.Default.Retention[2].Precision != 3600 {
+	if r.Rules().Default.Retention[2].Precision != 3600 {
 		t.FailNow()
 	}
 }
@@ -96,11 +98,11 @@ func TestParseClickhouseXML(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if r.Pattern[0].Retention[1].Age != 86400 {
+	if r.Rules().Pattern[0].Retention[1].Age != 86400 {
 		t.FailNow()
 	}
 
-	if r.Default.Retention[2].Precision != 3600 {
+	if r.Rules().Default.Retention[2].Precision != 3600 {
 		t.FailNow()
 	}
 }
@@ -121,7 +123,7 @@ func TestMetricPrecision(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := doMetricPrecision(test[0], 60, AggrSum)
+		result := doMetricPrecision(test[0], 60, AggrSum, nil, 0)
 		point.AssertListEq(t, test[1], result)
 	}
 }
@@ -184,4 +186,424 @@ func TestMetricStep(t *testing.T) {
 			}
 		})
 	}
+
+	// the coarsest step of all rules
+	maxSteps := []struct {
+		from         uint32
+		expectedStep uint32
+	}{
+		{now - 500, 60},
+		{now - 3700, 300},
+		{now - 87000, 3600},
+	}
+
+	for _, test := range maxSteps {
+		if step := r.MaxStep(test.from); step != test.expectedStep {
+			t.Fatalf("from=now-%v, expected max step=%v, actual max step=%v", now-test.from, test.expectedStep, step)
+		}
+	}
+}
+
+func TestXFilesFactor(t *testing.T) {
+	config := `
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<xFilesFactor>0.5</xFilesFactor>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 		<retention>
+ 			<age>3600</age>
+ 			<precision>240</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`
+	r, err := ParseXML([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Rules().Default.XFilesFactor != 0.5 {
+		t.Fatalf("expected xFilesFactor=0.5, actual %v", r.Rules().Default.XFilesFactor)
+	}
+
+	now := uint32(time.Now().Unix())
+	from := now - 7200
+	from = from - from%240
+	recent := now - 1200
+	recent = recent - recent%240
+
+	points := []point.Point{
+		// older than 3600, stored with precision 240
+		{MetricID: 1, Time: from, Value: 1},
+		// 1 of 4 points, dropped
+		{MetricID: 1, Time: recent, Value: 1},
+		// 2 of 4 points
+		{MetricID: 1, Time: recent + 240, Value: 2},
+		{MetricID: 1, Time: recent + 300, Value: 4},
+		// 2 of 4 points, the first one is rolled up from raw points
+		{MetricID: 1, Time: recent + 480, Value: 5},
+		{MetricID: 1, Time: recent + 490, Value: 7},
+		{MetricID: 1, Time: recent + 540, Value: 8},
+	}
+
+	result, step := r.RollupMetric("metric", from, points)
+	if step != 240 {
+		t.Fatalf("expected step=240, actual %v", step)
+	}
+
+	point.AssertListEq(t, []point.Point{
+		{MetricID: 1, Time: from, Value: 1},
+		{MetricID: 1, Time: recent + 240, Value: 3},
+		{MetricID: 1, Time: recent + 480, Value: 7},
+	}, result)
+
+	if _, err := ParseXML([]byte(strings.Replace(config, "0.5", "1.5", 1))); err == nil {
+		t.Fatal("expected error for xFilesFactor=1.5")
+	}
+}
+
+func TestXFilesFactorOldPoints(t *testing.T) {
+	r, err := ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<xFilesFactor>0.5</xFilesFactor>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 		<retention>
+ 			<age>86400</age>
+ 			<precision>3600</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// points older than the first retention are already merged to hourly points
+	now := uint32(time.Now().Unix())
+	from := now - 3*86400
+	from = from - from%3600
+
+	points := make([]point.Point, 0)
+	for i := uint32(0); i < 5; i++ {
+		points = append(points, point.Point{MetricID: 1, Time: from + i*3600, Value: float64(i)})
+	}
+
+	result, step := r.RollupMetric("metric", from, points)
+	if step != 3600 || len(result) != 5 {
+		t.Fatalf("expected 5 points with step 3600, actual %#v with step %d", result, step)
+	}
+
+	sql := r.Rules().Default.PointsPerIntervalSQL(3600, "RoundTime")
+	if sql != fmt.Sprintf("multiIf(RoundTime < %d, 1, 60)", now-86400) &&
+		sql != fmt.Sprintf("multiIf(RoundTime < %d, 1, 60)", now-86400+1) {
+		t.Fatalf("unexpected expression %s", sql)
+	}
+
+	if sql := r.Rules().Default.PointsPerIntervalSQL(60, "RoundTime"); sql != "1" {
+		t.Fatalf("unexpected expression %s", sql)
+	}
+}
+
+func TestBuildTaggedRegexp(t *testing.T) {
+	tests := []struct {
+		tagList  string
+		expected string
+	}{
+		{"cpu", `^cpu\?`},
+		{"cpu;host=a", `^cpu\?(.*&)?host=a(&.*)?$`},
+		{"nam.* ; tag2=val2 ; tag1=val1", `^nam.* \?(.*&)? tag1=val1&(.*&)? tag2=val2 (&.*)?$`},
+		{"tag2=val2;tag1=val1;", `[\?&]tag1=val1&(.*&)?tag2=val2(&.*)?$`},
+	}
+
+	for _, test := range tests {
+		if re := buildTaggedRegexp(test.tagList); re != test.expected {
+			t.Fatalf("tag list %#v: expected %#v, actual %#v", test.tagList, test.expected, re)
+		}
+	}
+}
+
+func TestMatchRuleType(t *testing.T) {
+	config := `
+<graphite_rollup>
+ 	<pattern>
+ 		<rule_type>tagged</rule_type>
+ 		<regexp>^cpu\?</regexp>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>10</precision>
+ 		</retention>
+ 	</pattern>
+ 	<pattern>
+ 		<rule_type>tag_list</rule_type>
+ 		<regexp>mem;host=a</regexp>
+ 		<function>max</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>20</precision>
+ 		</retention>
+ 	</pattern>
+ 	<pattern>
+ 		<rule_type>plain</rule_type>
+ 		<regexp>^cpu\.</regexp>
+ 		<function>sum</function>
+ 	</pattern>
+ 	<pattern>
+ 		<regexp>\.count$</regexp>
+ 		<function>sum</function>
+ 	</pattern>
+ 	<pattern>
+ 		<regexp>^cpu</regexp>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>30</precision>
+ 		</retention>
+ 	</pattern>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`
+	r, err := ParseXML([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		metric    string
+		function  string
+		precision uint32
+	}{
+		// function from plain pattern, retention from the next matched one
+		{"cpu.user", "sum", 30},
+		// retention from tagged pattern, function from default
+		{"cpu?host=a", "avg", 10},
+		// retention from tagged pattern, function from the next matched one
+		{"cpu?host=a&name=x.count", "sum", 10},
+		{"mem?dc=x&host=a", "max", 20},
+		{"mem?host=b", "avg", 60},
+		{"mem.free.count", "sum", 60},
+		{"mem.free", "avg", 60},
+	}
+
+	for _, test := range tests {
+		rule := r.Match(test.metric)
+		if rule.Aggr().Name() != test.function || rule.Retention[0].Precision != test.precision {
+			t.Fatalf("metric %#v: expected %s/%d, actual %s/%d",
+				test.metric, test.function, test.precision, rule.Aggr().Name(), rule.Retention[0].Precision)
+		}
+	}
+
+	if _, err := ParseXML([]byte(strings.Replace(config, "<rule_type>plain</rule_type>", "<rule_type>unknown</rule_type>", 1))); err == nil {
+		t.Fatal("expected error for unknown rule_type")
+	}
+}
+
+func TestAggrFunctions(t *testing.T) {
+	points := []point.Point{
+		{MetricID: 1, Time: 1, Value: 4, Timestamp: 10},
+		{MetricID: 1, Time: 2, Value: 1, Timestamp: 30},
+		{MetricID: 1, Time: 3, Value: 3, Timestamp: 20},
+		{MetricID: 1, Time: 4, Value: 2, Timestamp: 20},
+	}
+
+	tests := []struct {
+		function string
+		name     string
+		sql      string
+		expected float64
+	}{
+		{"count", "count", "toFloat64(count(Value))", 4},
+		{"median", "median", "quantile(0.5)(Value)", 2.5},
+		{"quantile(0.9)", "quantile(0.9)", "quantile(0.9)(Value)", 3.7},
+		{" quantile( 1 ) ", "quantile(1)", "quantile(1)(Value)", 4},
+		{"quantile(0)", "quantile(0)", "quantile(0)(Value)", 1},
+		{"argMax", "argMax", "argMax(Value, Timestamp)", 1},
+		{"anyLast", "anyLast", "argMax(Value, Time)", 2},
+	}
+
+	for _, test := range tests {
+		aggr, err := parseAggr(test.function)
+		if err != nil {
+			t.Fatalf("function %#v: %s", test.function, err.Error())
+		}
+		if aggr.Name() != test.name {
+			t.Fatalf("function %#v: expected name %#v, actual %#v", test.function, test.name, aggr.Name())
+		}
+		if sql := aggr.SQL("Value", "Time", "Timestamp"); sql != test.sql {
+			t.Fatalf("function %#v: expected sql %#v, actual %#v", test.function, test.sql, sql)
+		}
+		if v := aggr.Do(points); math.Abs(v-test.expected) > 1e-9 {
+			t.Fatalf("function %#v: expected %v, actual %v", test.function, test.expected, v)
+		}
+	}
+
+	for _, function := range []string{"quantile", "quantile(1.5)", "quantile(x)", "quantile(0.1, 0.2)", "unknown(1)", "median(0.5)"} {
+		if _, err := parseAggr(function); err == nil {
+			t.Fatalf("function %#v: expected error", function)
+		}
+	}
+}
+
+func TestRollupCount(t *testing.T) {
+	r, err := ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>count</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>10</precision>
+ 		</retention>
+ 		<retention>
+ 			<age>3600</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := uint32(time.Now().Unix())
+	from := now - 7200 - now%120
+
+	// 12 points in one minute: 6 intervals of the first retention with 2 points each
+	points := make([]point.Point, 0)
+	for i := uint32(0); i < 12; i++ {
+		points = append(points, point.Point{MetricID: 1, Time: from + i*5, Value: 42, Timestamp: from + i*5})
+	}
+
+	points, step := r.RollupMetric("foo.bar", from, points)
+	if step != 60 || len(points) != 1 || points[0].Value != 12 {
+		t.Fatalf("expected count 12 with step 60, actual %#v with step %d", points, step)
+	}
+
+	// single point of interval is counted too
+	points = []point.Point{
+		{MetricID: 1, Time: from, Value: 42.5},
+		{MetricID: 1, Time: from + 60, Value: 7},
+		{MetricID: 1, Time: from + 70, Value: 7},
+	}
+	points = doMetricPrecision(points, 60, r.Rules().Default.Aggr().f, nil, 0)
+	point.AssertListEq(t, []point.Point{
+		{MetricID: 1, Time: from, Value: 1},
+		{MetricID: 1, Time: from + 60, Value: 2},
+	}, points)
+
+	// already rolled up points are summed
+	points = []point.Point{
+		{MetricID: 1, Time: from, Value: 12},
+		{MetricID: 1, Time: from + 60, Value: 3},
+	}
+	points = RollupPoints(points, 60, 120, r.Rules().Default.Aggr(), 0)
+	if len(points) != 1 || points[0].Value != 15 {
+		t.Fatalf("expected count 15, actual %#v", points)
+	}
+}
+
+func TestMatchCache(t *testing.T) {
+	config := `
+<graphite_rollup>
+ 	<pattern>
+ 		<regexp>^carbon\.agents\.</regexp>
+ 		<function>sum</function>
+ 	</pattern>
+ 	<pattern>
+ 		<regexp>^(a|b)\.</regexp>
+ 		<function>max</function>
+ 	</pattern>
+ 	<pattern>
+ 		<regexp>\.min$</regexp>
+ 		<function>min</function>
+ 	</pattern>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`
+	r, err := ParseXML([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := r.Rules()
+
+	prefixes := []string{"carbon.agents.", "", ""}
+	for i, p := range rules.Pattern {
+		if p.prefix != prefixes[i] {
+			t.Fatalf("pattern %#v: expected prefix %#v, actual %#v", p.Regexp, prefixes[i], p.prefix)
+		}
+	}
+
+	// regexp of pattern with other prefix is not evaluated
+	re := rules.Pattern[0].re
+	rules.Pattern[0].re = nil
+	if p := rules.match("b.cpu"); p.Function != "max" {
+		t.Fatalf("unexpected function %#v", p.Function)
+	}
+	rules.Pattern[0].re = re
+
+	p := rules.Match("carbon.agents.cpu")
+	if p.Function != "sum" {
+		t.Fatalf("unexpected function %#v", p.Function)
+	}
+	if rules.Match("carbon.agents.cpu") != p {
+		t.Fatal("match is not cached")
+	}
+	if p := rules.Match("carbon.cpu.min"); p.Function != "min" {
+		t.Fatalf("unexpected function %#v", p.Function)
+	}
+	if p := rules.Match("b.cpu"); p.Function != "max" {
+		t.Fatalf("unexpected function %#v", p.Function)
+	}
+
+	// cache is reset when full
+	c := newMatchCache(2)
+	c.set("a", p)
+	c.set("b", p)
+	c.set("c", p)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("cache is not reset")
+	}
+	if _, ok := c.get("c"); !ok {
+		t.Fatal("value not cached")
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := [][2]string{
+		{`^a\.b\..*`, "a.b."},
+		{`^carbon\.agents\..*`, "carbon.agents."},
+		{`^stats\.timers\..*\.count$`, "stats.timers."},
+		{`^carbon\.`, "carbon."},
+		{`^ab*`, "a"},
+		{`^ab?\.`, "a"},
+		{`^a|b`, ""},
+		{`^(a|b)\.`, ""},
+		{`(?i)^abc`, ""},
+		{`a\.b`, ""},
+		{`\.count$`, ""},
+	}
+
+	for _, tt := range tests {
+		if prefix := literalPrefix(tt[0]); prefix != tt[1] {
+			t.Fatalf("regexp %#v: expected prefix %#v, actual %#v", tt[0], tt[1], prefix)
+		}
+	}
 }
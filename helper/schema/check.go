@@ -0,0 +1,148 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+)
+
+// Column is a column expected in table. Any type is allowed if Types is empty
+type Column struct {
+	Name  string
+	Types []string
+}
+
+var (
+	typesString   = []string{"String"}
+	typesTime     = []string{"UInt32", "DateTime"}
+	typesFloat64  = []string{"Float64"}
+	typesDate     = []string{"Date"}
+	typesUInt     = []string{"UInt8", "UInt16", "UInt32", "UInt64"}
+	typesArrayStr = []string{"Array(String)"}
+)
+
+// dateColumns returns Date column if table has it
+func (s *Schema) dateColumns() []Column {
+	if s.NoDate {
+		return nil
+	}
+	return []Column{{s.Date, typesDate}}
+}
+
+// DataColumns returns columns of points table read by render
+func (s *Schema) DataColumns() []Column {
+	return append(s.dateColumns(),
+		Column{s.Path, typesString},
+		Column{s.Time, typesTime},
+		Column{s.Value, typesFloat64},
+		Column{s.Timestamp, typesTime},
+	)
+}
+
+// TreeColumns returns columns of tree and reverse tree tables
+func (s *Schema) TreeColumns() []Column {
+	return []Column{
+		{s.Path, typesString},
+		{s.Level, typesUInt},
+		{s.Deleted, typesUInt},
+		{s.Version, typesUInt},
+	}
+}
+
+// DateTreeColumns returns columns of date tree table of version 1, 2 or 3
+func (s *Schema) DateTreeColumns(version int) []Column {
+	columns := append(s.dateColumns(),
+		Column{s.Path, typesString},
+		Column{s.Level, typesUInt},
+	)
+
+	if version == 2 || version == 3 {
+		columns = append(columns,
+			Column{s.Deleted, typesUInt},
+			Column{s.Version, typesUInt},
+		)
+	}
+
+	return columns
+}
+
+// TaggedColumns returns columns of tagged table
+func (s *Schema) TaggedColumns() []Column {
+	return append(s.dateColumns(),
+		Column{s.Path, typesString},
+		Column{s.Tag1, typesString},
+		Column{s.Tags, typesArrayStr},
+		Column{s.Deleted, typesUInt},
+		Column{s.Version, typesUInt},
+	)
+}
+
+// TagColumns returns columns of tag table
+func (s *Schema) TagColumns() []Column {
+	return []Column{
+		{s.Path, typesString},
+		{s.Level, typesUInt},
+		{s.Tag1, typesString},
+		{s.Tags, typesArrayStr},
+		{s.Version, typesUInt},
+	}
+}
+
+// Describe returns types of table columns by names
+func Describe(ctx context.Context, url string, table string, opts clickhouse.Options) (map[string]string, error) {
+	body, err := clickhouse.Query(ctx, url, fmt.Sprintf("DESCRIBE TABLE %s FORMAT TabSeparated", table), table, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for _, row := range bytes.Split(body, []byte{'\n'}) {
+		f := strings.Split(string(row), "\t")
+		if len(f) < 2 {
+			continue
+		}
+		result[f[0]] = f[1]
+	}
+
+	return result, nil
+}
+
+// CheckColumns returns problems of table with types of columns described
+func CheckColumns(described map[string]string, columns []Column) []string {
+	problems := make([]string, 0)
+
+	for _, c := range columns {
+		t, ok := described[c.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("column %s not found", c.Name))
+			continue
+		}
+
+		if len(c.Types) == 0 {
+			continue
+		}
+
+		// LowCardinality(T) is read as T
+		base := t
+		if strings.HasPrefix(base, "LowCardinality(") && strings.HasSuffix(base, ")") {
+			base = base[len("LowCardinality(") : len(base)-1]
+		}
+
+		found := false
+		for _, expected := range c.Types {
+			if base == expected {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			problems = append(problems, fmt.Sprintf("column %s has type %s, expected %s", c.Name, t, strings.Join(c.Types, " or ")))
+		}
+	}
+
+	return problems
+}
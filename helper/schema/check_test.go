@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckColumns(t *testing.T) {
+	assert := assert.New(t)
+
+	described := map[string]string{
+		"Path":      "LowCardinality(String)",
+		"Value":     "Float32",
+		"Time":      "DateTime",
+		"Date":      "Date",
+		"Timestamp": "UInt32",
+	}
+
+	assert.Equal([]string{"column Value has type Float32, expected Float64"}, CheckColumns(described, Default().DataColumns()))
+
+	s := Default()
+	s.Value = "Val"
+	s.NoDate = true
+	delete(described, "Date")
+	assert.Equal([]string{"column Val not found"}, CheckColumns(described, s.DataColumns()))
+
+	assert.Equal([]string{"column Date not found", "column Level not found"}, CheckColumns(described, Default().DateTreeColumns(1)))
+	assert.Equal([]string{"column Date not found", "column Level not found", "column Deleted not found", "column Version not found"}, CheckColumns(described, Default().DateTreeColumns(2)))
+}
+
+func TestDescribe(t *testing.T) {
+	assert := assert.New(t)
+
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		query = string(body)
+		w.Write([]byte("Path\tString\t\t\nValue\tFloat64\t\t\n"))
+	}))
+	defer srv.Close()
+
+	described, err := Describe(context.Background(), srv.URL, "graphite", clickhouse.Options{})
+	assert.NoError(err)
+	assert.Equal("DESCRIBE TABLE graphite FORMAT TabSeparated", query)
+	assert.Equal(map[string]string{"Path": "String", "Value": "Float64"}, described)
+}
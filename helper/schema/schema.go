@@ -0,0 +1,76 @@
+package schema
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schema is column names of graphite tables. Empty names are replaced with default ones
+type Schema struct {
+	Path      string `toml:"path"`
+	Time      string `toml:"time"`
+	Value     string `toml:"value"`
+	Timestamp string `toml:"timestamp"`
+	Date      string `toml:"date"`
+	Level     string `toml:"level"`
+	Deleted   string `toml:"deleted"`
+	Version   string `toml:"version"`
+	Tag1      string `toml:"tag1"`
+	Tags      string `toml:"tags"`
+	// Table has no Date column (e.g. partitioned by Time). Conditions on Date are not used
+	NoDate bool `toml:"no-date"`
+}
+
+// Default returns schema of carbon-clickhouse tables
+func Default() *Schema {
+	s := &Schema{}
+	s.SetDefaults()
+	return s
+}
+
+// SetDefaults replaces empty column names with default ones
+func (s *Schema) SetDefaults() {
+	set := func(column *string, name string) {
+		if *column == "" {
+			*column = name
+		}
+	}
+
+	set(&s.Path, "Path")
+	set(&s.Time, "Time")
+	set(&s.Value, "Value")
+	set(&s.Timestamp, "Timestamp")
+	set(&s.Date, "Date")
+	set(&s.Level, "Level")
+	set(&s.Deleted, "Deleted")
+	set(&s.Version, "Version")
+	set(&s.Tag1, "Tag1")
+	set(&s.Tags, "Tags")
+}
+
+// DateWhere returns condition on Date column for time range [from, until]. Empty if table has no Date column
+func (s *Schema) DateWhere(from int64, until int64) string {
+	if s.NoDate {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"%s >='%s' AND %s <= '%s'",
+		s.Date, time.Unix(from, 0).Format("2006-01-02"),
+		s.Date, time.Unix(until, 0).Format("2006-01-02"),
+	)
+}
+
+// DateFrom returns condition on Date column for time range started from date. Empty if table has no Date column
+func (s *Schema) DateFrom(from time.Time) string {
+	if s.NoDate {
+		return ""
+	}
+
+	return fmt.Sprintf("%s >= '%s'", s.Date, from.Format("2006-01-02"))
+}
+
+// NotDeleted returns condition of the last version of path which is not deleted
+func (s *Schema) NotDeleted() string {
+	return fmt.Sprintf("argMax(%s, %s)==0", s.Deleted, s.Version)
+}
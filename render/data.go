@@ -1,16 +1,12 @@
 package render
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
 	"errors"
-	"io"
-	"math"
 	"strings"
 	"unsafe"
 
 	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
 )
 
 func unsafeString(b []byte) string {
@@ -51,22 +47,44 @@ func ReadUvarint(array []byte) (uint64, int, error) {
 	}
 }
 
+// Data is request context used to roll up streamed points of metrics to series
 type Data struct {
-	body    []byte // raw RowBinary from clickhouse
-	Points  *point.Points
-	nameMap map[string]string
 	Aliases map[string][]string
+	From    uint32        // requested time range
+	Until   uint32        // requested time range
+	Rollup  *rollup.Rules // rollup rules of selected data table
+	// Points are already rolled up in clickhouse query
+	Aggregated bool
+	// Max count of points per series. 0 - unlimited
+	MaxDataPoints int64
+	// Explicit rollup and consolidation functions by target (pathExpression)
+	ConsolidateBy map[string]*rollup.Aggr
 }
 
-var EmptyData *Data = &Data{Points: point.NewPoints()}
+// metricSeries rolls up points of ONE metric sorted by time and calls callback for each target alias of metric
+func (d *Data) metricSeries(metricName string, points []point.Point, callback func(s *series)) {
+	a := d.Aliases[metricName]
+	for k := 0; k < len(a); k += 2 {
+		pp := points
+		if k+2 < len(a) {
+			// rollup modifies points. Keep source points for next aliases
+			pp = make([]point.Point, len(points))
+			copy(pp, points)
+		}
 
-func (d *Data) finalName(name string) string {
-	s, ok := d.nameMap[name]
-	if !ok {
-		d.nameMap[name] = name
-		return name
+		pp, step := d.rollupMetric(metricName, a[k+1], pp)
+
+		callback(&series{
+			name:           a[k],
+			pathExpression: a[k+1],
+			points:         pp,
+			step:           step,
+			from:           d.From,
+			until:          d.Until,
+			aggr:           d.consolidateAggr(metricName, a[k+1]),
+			xFilesFactor:   d.Rollup.Match(metricName).XFilesFactor,
+		})
 	}
-	return s
 }
 
 // DataSplitFunc is split function for bufio.Scanner for read row binary records with data
@@ -101,75 +119,47 @@ func DataSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err erro
 	return tokenLen, data[:tokenLen], nil
 }
 
-func DataParse(bodyReader io.Reader, extraPoints *point.Points, isReverse bool) (*Data, error) {
-	d := &Data{
-		Points: point.NewPoints(),
+// consolidateStep returns step for at most maxDataPoints points in time range [from, until]
+func consolidateStep(step uint32, from, until uint32, maxDataPoints int64) uint32 {
+	if maxDataPoints <= 0 || step == 0 || until < from {
+		return step
 	}
 
-	pp := d.Points
-
-	// add extraPoints. With NameToID
-	if extraPoints != nil {
-		extraList := extraPoints.List()
-		for i := 0; i < len(extraList); i++ {
-			pp.AppendPoint(
-				pp.MetricID(extraPoints.MetricName(extraList[i].MetricID)),
-				extraList[i].Value,
-				extraList[i].Time,
-				extraList[i].Timestamp,
-			)
-		}
+	count := int64((until-from)/step) + 1
+	if count <= maxDataPoints {
+		return step
 	}
 
-	nameBuf := make([]byte, 65536)
-	name := []byte{}
-	var metricID uint32
-
-	scanner := bufio.NewScanner(bodyReader)
-	scanner.Buffer(make([]byte, 1048576), 1048576)
-	scanner.Split(DataSplitFunc)
-
-	for scanner.Scan() {
-		row := scanner.Bytes()
-
-		namelen, readBytes, err := ReadUvarint(row)
-		if err != nil {
-			return nil, errClickHouseResponse
-		}
-		row = row[int(readBytes):]
-
-		newName := row[:int(namelen)]
-		row = row[int(namelen):]
-
-		if bytes.Compare(newName, name) != 0 {
-			if len(newName) > len(nameBuf) {
-				name = make([]byte, len(newName))
-				copy(name, newName)
-			} else {
-				copy(nameBuf, newName)
-				name = nameBuf[:len(newName)]
-			}
-			if isReverse {
-				metricID = pp.MetricID(reversePath(string(name)))
-			} else {
-				metricID = pp.MetricID(string(name))
-			}
-		}
-
-		time := binary.LittleEndian.Uint32(row[:4])
-		row = row[4:]
-
-		value := math.Float64frombits(binary.LittleEndian.Uint64(row[:8]))
-		row = row[8:]
+	valuesPerPoint := (count + maxDataPoints - 1) / maxDataPoints
+	return step * uint32(valuesPerPoint)
+}
 
-		timestamp := binary.LittleEndian.Uint32(row[:4])
+// consolidateAggr returns function for consolidation of series requested by target pathExpression
+func (d *Data) consolidateAggr(metricName string, pathExpression string) *rollup.Aggr {
+	if aggr := d.ConsolidateBy[pathExpression]; aggr != nil {
+		return aggr
+	}
+	return d.Rollup.Match(metricName).Aggr()
+}
 
-		pp.AppendPoint(metricID, value, time, timestamp)
+// rollupMetric rolls up points of ONE metric if it is not done by clickhouse
+// and consolidates them to MaxDataPoints. Returns new points and step
+func (d *Data) rollupMetric(metricName string, pathExpression string, points []point.Point) ([]point.Point, uint32) {
+	// explicit consolidation function of target replaces rollup function
+	aggr := d.ConsolidateBy[pathExpression]
+
+	var step uint32
+	if d.Aggregated {
+		points, step = d.Rollup.RollupAggregatedBy(metricName, d.From, points, aggr)
+	} else {
+		points, step = d.Rollup.RollupMetricBy(metricName, d.From, points, aggr)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	newStep := consolidateStep(step, d.From, d.Until, d.MaxDataPoints)
+	if newStep == step {
+		return points, step
 	}
 
-	return d, nil
+	xFilesFactor := d.Rollup.Match(metricName).XFilesFactor
+	return rollup.RollupPoints(points, step, newStep, d.consolidateAggr(metricName, pathExpression), xFilesFactor), newStep
 }
@@ -3,9 +3,11 @@ package render
 import (
 	"bytes"
 	"fmt"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/lomik/graphite-clickhouse/helper/RowBinary"
+	"github.com/lomik/graphite-clickhouse/helper/point"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,14 +32,37 @@ func makeData(points []testPoint) []byte {
 	return buf.Bytes()
 }
 
+// streamPoints returns all points read by DataStream from body
+func streamPoints(body []byte) ([]testPoint, error) {
+	var result []testPoint
+	err := DataStream(bytes.NewReader(body), false, func(metricName string, points []point.Point) error {
+		for _, p := range points {
+			result = append(result, testPoint{metricName, p.Value, p.Time, p.Timestamp})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// testReply streams points of body through data to reply writer of format
+func testReply(format string, data *Data, points []testPoint) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	out := newReply(w, format)
+
+	DataStream(bytes.NewReader(makeData(points)), false, func(metricName string, points []point.Point) error {
+		data.metricSeries(metricName, points, out.write)
+		return nil
+	})
+
+	out.end()
+	return w
+}
+
 func TestDataParse(t *testing.T) {
 	t.Run("empty response", func(t *testing.T) {
-		body := []byte{}
-		r := bytes.NewReader(body)
-
-		d, err := DataParse(r, nil, false)
+		points, err := streamPoints([]byte{})
 		assert.NoError(t, err)
-		assert.Empty(t, d.Points.List())
+		assert.Empty(t, points)
 	})
 
 	t.Run("ok", func(t *testing.T) {
@@ -62,18 +87,9 @@ func TestDataParse(t *testing.T) {
 
 		for i := 0; i < len(table); i++ {
 			t.Run(fmt.Sprintf("ok #%d", i), func(t *testing.T) {
-				body := makeData(table[i])
-
-				r := bytes.NewReader(body)
-
-				d, err := DataParse(r, nil, false)
+				points, err := streamPoints(makeData(table[i]))
 				assert.NoError(t, err)
-				for j := 0; j < len(table[i]); j++ {
-					assert.Equal(t, table[i][j].Metric, d.Points.MetricName(d.Points.List()[j].MetricID))
-					assert.Equal(t, table[i][j].Time, d.Points.List()[j].Time)
-					assert.Equal(t, table[i][j].Value, d.Points.List()[j].Value)
-					assert.Equal(t, table[i][j].Timestamp, d.Points.List()[j].Timestamp)
-				}
+				assert.Equal(t, table[i], points)
 			})
 		}
 	})
@@ -89,12 +105,47 @@ func TestDataParse(t *testing.T) {
 		})
 
 		for i := 1; i < len(body)-1; i++ {
-			r := bytes.NewReader(body[:i])
-
-			d, err := DataParse(r, nil, false)
+			_, err := streamPoints(body[:i])
 			assert.Error(t, err)
-			assert.Nil(t, d)
 		}
 	})
 
 }
+
+func TestDataStream(t *testing.T) {
+	body := makeData([]testPoint{
+		{"a.b", 1, 1520056680, 1520056680},
+		{"a.b", 2, 1520056740, 1520056740},
+		{"a.c", 3, 1520056680, 1520056680},
+		{"b.c", 4, 1520056680, 1520056680},
+		{"b.c", 5, 1520056740, 1520056740},
+	})
+
+	type result struct {
+		name   string
+		values []float64
+	}
+
+	var results []result
+
+	err := DataStream(bytes.NewReader(body), false, func(metricName string, points []point.Point) error {
+		values := make([]float64, 0, len(points))
+		for _, p := range points {
+			values = append(values, p.Value)
+		}
+		results = append(results, result{metricName, values})
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []result{
+		{"a.b", []float64{1, 2}},
+		{"a.c", []float64{3}},
+		{"b.c", []float64{4, 5}},
+	}, results)
+
+	err = DataStream(bytes.NewReader(body[:len(body)-1]), false, func(metricName string, points []point.Point) error {
+		return nil
+	})
+	assert.Error(t, err)
+}
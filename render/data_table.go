@@ -1,24 +1,32 @@
 package render
 
 import (
+	"sort"
 	"time"
 
 	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
 	"github.com/lomik/graphite-clickhouse/helper/rollup"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
 )
 
 func SelectDataTable(cfg *config.Config, from int64, until int64, targets []string) (string, bool, *rollup.Rollup) {
-	now := time.Now().Unix()
+	t := newDataTableRange(cfg, selectDataTable(cfg, from, until, until-from, time.Now().Unix(), targets), from, until)
+	return t.Table, t.Reverse, t.Rollup
+}
 
+// selectDataTable returns the first data table for time range [from, until] of request with interval.
+// Returns nil if no table is matched and [clickhouse] data-table should be used
+func selectDataTable(cfg *config.Config, from int64, until int64, interval int64, now int64, targets []string) *config.DataTable {
 TableLoop:
 	for i := 0; i < len(cfg.DataTable); i++ {
 		t := &cfg.DataTable[i]
 
-		if t.MaxInterval != nil && (until-from) > int64(t.MaxInterval.Value().Seconds()) {
+		if t.MaxInterval != nil && interval > int64(t.MaxInterval.Value().Seconds()) {
 			continue TableLoop
 		}
 
-		if t.MinInterval != nil && (until-from) < int64(t.MinInterval.Value().Seconds()) {
+		if t.MinInterval != nil && interval < int64(t.MinInterval.Value().Seconds()) {
 			continue TableLoop
 		}
 
@@ -54,12 +62,118 @@ TableLoop:
 			}
 		}
 
-		if t.Rollup != nil {
-			return t.Table, t.Reverse, t.Rollup
+		return t
+	}
+
+	return nil
+}
+
+// DataTableRange is a part of requested time range read from one data table
+type DataTableRange struct {
+	Table   string
+	Reverse bool
+	Rollup  *rollup.Rollup
+	Url     string
+	Options clickhouse.Options
+	Schema  *schema.Schema
+	From    int64
+	Until   int64
+	config  *config.DataTable
+}
+
+// newDataTableRange returns range of data table t. Options not set in t are taken from [clickhouse] section
+func newDataTableRange(cfg *config.Config, t *config.DataTable, from int64, until int64) *DataTableRange {
+	r := &DataTableRange{
+		Table:  cfg.ClickHouse.DataTable,
+		Rollup: cfg.Rollup,
+		Url:    cfg.ClickHouse.Url,
+		Schema: cfg.ClickHouse.DataSchema,
+		Options: clickhouse.Options{
+			Timeout:        cfg.ClickHouse.DataTimeout.Value(),
+			ConnectTimeout: cfg.ClickHouse.ConnectTimeout.Value(),
+		},
+		From:   from,
+		Until:  until,
+		config: t,
+	}
+
+	if t == nil {
+		return r
+	}
+
+	r.Table = t.Table
+	r.Reverse = t.Reverse
+	if t.Rollup != nil {
+		r.Rollup = t.Rollup
+	}
+	if t.Url != "" {
+		r.Url = t.Url
+	}
+	if t.DataTimeout != nil {
+		r.Options.Timeout = t.DataTimeout.Value()
+	}
+	if t.ConnectTimeout != nil {
+		r.Options.ConnectTimeout = t.ConnectTimeout.Value()
+	}
+	r.Options.Settings = t.Settings
+	if t.Schema != nil {
+		r.Schema = t.Schema
+	}
+
+	return r
+}
+
+// SelectDataTables returns data tables of request. If stitch-data-tables is enabled time range is split
+// by max-age and min-age of tables and every part is read from its own table. Neighbour parts with the same table are joined
+func SelectDataTables(cfg *config.Config, from int64, until int64, targets []string) []*DataTableRange {
+	return selectDataTables(cfg, from, until, time.Now().Unix(), targets)
+}
+
+func selectDataTables(cfg *config.Config, from int64, until int64, now int64, targets []string) []*DataTableRange {
+	if !cfg.ClickHouse.StitchDataTables {
+		t := selectDataTable(cfg, from, until, until-from, now, targets)
+		return []*DataTableRange{newDataTableRange(cfg, t, from, until)}
+	}
+
+	// the first second of every part
+	bounds := make([]int64, 0)
+	addBound := func(b int64) {
+		if b > from && b <= until {
+			bounds = append(bounds, b)
+		}
+	}
+
+	for i := 0; i < len(cfg.DataTable); i++ {
+		t := &cfg.DataTable[i]
+		if t.MaxAge != nil {
+			addBound(now - int64(t.MaxAge.Value().Seconds()))
+		}
+		if t.MinAge != nil {
+			addBound(now - int64(t.MinAge.Value().Seconds()) + 1)
+		}
+	}
+
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	bounds = append(bounds, until+1)
+
+	result := make([]*DataTableRange, 0)
+	start := from
+	for _, b := range bounds {
+		if b <= start {
+			// duplicate bound
+			continue
+		}
+
+		t := selectDataTable(cfg, start, b-1, until-from, now, targets)
+
+		if l := len(result); l > 0 && result[l-1].config == t {
+			result[l-1].Until = b - 1
 		} else {
-			return t.Table, t.Reverse, cfg.Rollup
+			result = append(result, newDataTableRange(cfg, t, start, b-1))
 		}
+
+		start = b
 	}
 
-	return cfg.ClickHouse.DataTable, false, cfg.Rollup
+	return result
 }
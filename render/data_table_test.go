@@ -0,0 +1,80 @@
+package render
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/config"
+)
+
+func TestSelectDataTables(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := config.New()
+	cfg.DataTable = []config.DataTable{
+		{Table: "graphite_recent", MaxAge: &config.Duration{Duration: time.Hour}},
+		{Table: "graphite_archive", MinAge: &config.Duration{Duration: 24 * time.Hour}},
+	}
+
+	now := time.Now().Unix()
+	from := now - 48*3600
+	hourAgo := now - 3600
+	dayAgo := now - 24*3600
+
+	assertRange := func(table string, from, until int64, r *DataTableRange) {
+		assert.Equal(table, r.Table)
+		assert.Equal(from, r.From)
+		assert.Equal(until, r.Until)
+	}
+
+	// without stitching the whole range is read from default table
+	tables := selectDataTables(cfg, from, now, now, []string{"a.*"})
+	assert.Len(tables, 1)
+	assert.Equal("graphite", tables[0].Table)
+
+	cfg.ClickHouse.StitchDataTables = true
+
+	tables = selectDataTables(cfg, from, now, now, []string{"a.*"})
+	if assert.Len(tables, 3) {
+		assertRange("graphite_archive", from, dayAgo, tables[0])
+		assertRange("graphite", dayAgo+1, hourAgo-1, tables[1])
+		assertRange("graphite_recent", hourAgo, now, tables[2])
+	}
+
+	// range inside one table
+	tables = selectDataTables(cfg, now-600, now, now, []string{"a.*"})
+	if assert.Len(tables, 1) {
+		assertRange("graphite_recent", now-600, now, tables[0])
+	}
+}
+
+func TestDataTableOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := config.New()
+	cfg.ClickHouse.Url = "http://localhost:8123/"
+	cfg.DataTable = []config.DataTable{
+		{
+			Table:       "graphite_archive",
+			MinAge:      &config.Duration{Duration: 24 * time.Hour},
+			Url:         "http://archive:8123/",
+			DataTimeout: &config.Duration{Duration: 5 * time.Minute},
+			Settings:    map[string]string{"max_threads": "4"},
+		},
+	}
+
+	now := time.Now().Unix()
+
+	tables := selectDataTables(cfg, now-600, now, now, []string{"a.*"})
+	assert.Equal("http://localhost:8123/", tables[0].Url)
+	assert.Equal(cfg.ClickHouse.DataTimeout.Value(), tables[0].Options.Timeout)
+	assert.Nil(tables[0].Options.Settings)
+
+	tables = selectDataTables(cfg, now-3*24*3600, now-2*24*3600, now, []string{"a.*"})
+	assert.Equal("http://archive:8123/", tables[0].Url)
+	assert.Equal(5*time.Minute, tables[0].Options.Timeout)
+	assert.Equal(cfg.ClickHouse.ConnectTimeout.Value(), tables[0].Options.ConnectTimeout)
+	assert.Equal(map[string]string{"max_threads": "4"}, tables[0].Options.Settings)
+}
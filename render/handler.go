@@ -5,18 +5,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"strconv"
+	"sort"
+	"sync"
 	"time"
 
-	"github.com/lomik/graphite-clickhouse/helper/rollup"
-
 	"go.uber.org/zap"
 
 	"github.com/lomik/graphite-clickhouse/config"
 	"github.com/lomik/graphite-clickhouse/finder"
+	"github.com/lomik/graphite-clickhouse/helper/cache"
 	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
 	"github.com/lomik/graphite-clickhouse/helper/log"
 	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
 
 	graphitePickle "github.com/lomik/graphite-pickle"
 )
@@ -93,38 +94,127 @@ func (h *Handler) queryCarbonlink(parentCtx context.Context, logger *zap.Logger,
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	logger := log.FromContext(r.Context())
 
-	var prefix string
-	var err error
-
 	r.ParseMultipartForm(1024 * 1024)
 
-	fromTimestamp, err := strconv.ParseInt(r.FormValue("from"), 10, 32)
+	fetchRequests, err := ParseRequest(r)
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	untilTimestamp, err := strconv.ParseInt(r.FormValue("until"), 10, 32)
-	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		return
+	format := r.FormValue("format")
+	useCache := !cache.NoCache(r)
+
+	renderCache := h.config.RenderCache.Cache
+	var cacheKey string
+	var cached *bytes.Buffer
+
+	if useCache && renderCache != nil {
+		cacheKey = h.renderCacheKey(format, fetchRequests)
+		if body, ok := renderCache.Get(cacheKey); ok {
+			renderCache.Log(r.Context(), "render", true)
+			if writer := newReplyWriter(format, nil); writer != nil {
+				w.Header().Set("Content-Type", writer.contentType())
+			}
+			w.Write(body)
+			return
+		}
+		renderCache.Log(r.Context(), "render", false)
+		cached = new(bytes.Buffer)
 	}
 
-	aliases := make(map[string][]string)
-	targets := make([]string, 0)
+	out := newReply(w, format)
+	if cached != nil {
+		out.tee(cached)
+	}
+
+	for _, fetchRequest := range fetchRequests {
+		err := h.fetch(r.Context(), fetchRequest, out, useCache)
+		if _, ok := err.(*finder.LimitError); ok {
+			out.fail(logger, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("fetch failed", zap.Error(err))
+			out.fail(logger, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	out.end()
+	logger.Debug("reply", zap.String("runtime", out.duration.String()), zap.Duration("runtime_ns", out.duration))
+
+	if cached != nil {
+		renderCache.Set(cacheKey, cached.Bytes(), h.config.RenderCache.TTL.Value())
+	}
+}
+
+// renderCacheKey returns key of render cache. Time ranges are rounded to the coarsest rollup step
+// which any rule of data tables used by fetch can produce for their age, so requests of the same dashboard
+// made within one step share the cached reply. Targets are not resolved to metrics yet and can't select the rule themselves.
+// Tables and their steps are the part of key, so reply stitched from several tables is not shared with other table set
+func (h *Handler) renderCacheKey(format string, fetchRequests MultiFetchRequest) string {
+	key := new(bytes.Buffer)
+	key.WriteString(format)
+
+	for _, fetchRequest := range fetchRequests {
+		targets := make([]string, 0, len(fetchRequest.Targets))
+		for _, t := range fetchRequest.Targets {
+			targets = append(targets, t.Name)
+		}
 
-	for t := 0; t < len(r.Form["target"]); t++ {
-		target := r.Form["target"][t]
-		if len(target) == 0 {
-			continue
+		tables := SelectDataTables(h.config, fetchRequest.From, fetchRequest.Until, targets)
+
+		step := int64(1)
+		for _, t := range tables {
+			tableStep := int64(t.Rollup.MaxStep(uint32(t.From)))
+			if tableStep > step {
+				step = tableStep
+			}
+			fmt.Fprintf(key, ";%s@%d", t.Table, tableStep)
 		}
+
+		fmt.Fprintf(key, ";%d;%d;%d",
+			fetchRequest.From-fetchRequest.From%step,
+			fetchRequest.Until-fetchRequest.Until%step,
+			fetchRequest.MaxDataPoints,
+		)
+		for _, t := range fetchRequest.Targets {
+			fmt.Fprintf(key, ";%s:%s", t.Name, t.ConsolidateBy)
+		}
+	}
+
+	return key.String()
+}
+
+// fetch finds metrics for all targets of request, reads its points from clickhouse ordered by Path
+// and writes every series to reply as soon as all its points are read.
+// If time range is split to several data tables series are merged and written after all tables are read
+func (h *Handler) fetch(ctx context.Context, fetchRequest *FetchRequest, out *reply, useCache bool) error {
+	logger := log.FromContext(ctx)
+
+	fromTimestamp := fetchRequest.From
+	untilTimestamp := fetchRequest.Until
+
+	targets := make([]string, 0, len(fetchRequest.Targets))
+	aliases := make(map[string][]string)
+	consolidateBy := make(map[string]*rollup.Aggr)
+
+	// found metrics and nodes of every target are limited
+	limit := finder.Limit{Name: "max-series-per-target", Max: int64(h.config.Limits.MaxSeriesPerTarget)}
+
+	for _, t := range fetchRequest.Targets {
+		target := t.Name
 		targets = append(targets, target)
 
+		if t.ConsolidateBy != "" {
+			consolidateBy[target] = consolidateAggr(t.ConsolidateBy)
+		}
+
 		// Search in small index table first
-		fndResult, err := finder.Find(h.config, r.Context(), target, fromTimestamp, untilTimestamp)
+		fndResult, err := finder.Find(h.config, ctx, target, fromTimestamp, untilTimestamp, limit, useCache)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
 
 		fndSeries := fndResult.Series()
@@ -140,6 +230,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if err := finder.CheckLimit(ctx, "max-series", int64(len(aliases)), int64(h.config.Limits.MaxSeries)); err != nil {
+		return err
+	}
+
 	metricList := make([][]byte, len(aliases))
 	index := 0
 	for metric, _ := range aliases {
@@ -147,117 +241,251 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		index++
 	}
 
-	pointsTable, isReverse, rollupObj := SelectDataTable(h.config, fromTimestamp, untilTimestamp, targets)
+	tables := SelectDataTables(h.config, fromTimestamp, untilTimestamp, targets)
+	// rules may be updated in background, use the same ones for whole request
+	rules := make([]*rollup.Rules, len(tables))
+	for i, t := range tables {
+		rules[i] = t.Rollup.Rules()
+	}
+
+	if h.config.Limits.MaxPoints > 0 {
+		var points int64
+		for i, t := range tables {
+			points += estimatePoints(metricList, rules[i], uint32(t.From), uint32(t.Until))
+		}
+		if err := finder.CheckLimit(ctx, "max-points", points, h.config.Limits.MaxPoints); err != nil {
+			return err
+		}
+	}
+
+	if len(metricList) == 0 {
+		// Nothing to reply
+		return nil
+	}
 
-	var maxStep uint32
-	listBuf := bytes.NewBuffer(nil)
+	metricsAggr := metricsConsolidateBy(aliases, consolidateBy)
 
-	// make Path IN (...), calculate max step
-	for index, m := range metricList {
-		if len(m) == 0 {
-			continue
+	// start carbonlink request
+	carbonlinkResponseRead := h.queryCarbonlink(ctx, logger, metricList)
+
+	if len(tables) == 1 {
+		data := &Data{
+			Aliases:       aliases,
+			From:          uint32(fromTimestamp),
+			Until:         uint32(untilTimestamp),
+			Rollup:        rules[0],
+			Aggregated:    h.config.ClickHouse.InternalAggregation,
+			MaxDataPoints: fetchRequest.MaxDataPoints,
+			ConsolidateBy: consolidateBy,
 		}
-		step := rollupObj.Step(unsafeString(m), uint32(fromTimestamp))
-		if step > maxStep {
-			maxStep = step
+
+		return h.fetchTable(ctx, tables[0], data, metricList, metricsAggr, carbonlinkResponseRead, out.write)
+	}
+
+	// series of every table are collected and merged after all tables are read
+	parts := make(map[seriesKey][]*series)
+	collect := func(s *series) {
+		// points may be reused by caller
+		s.points = append([]point.Point(nil), s.points...)
+		key := seriesKey{name: s.name, pathExpression: s.pathExpression}
+		parts[key] = append(parts[key], s)
+	}
+
+	noCarbonlink := func() *point.Points { return nil }
+
+	for i, t := range tables {
+		data := &Data{
+			Aliases:       aliases,
+			From:          uint32(t.From),
+			Until:         uint32(t.Until),
+			Rollup:        rules[i],
+			Aggregated:    h.config.ClickHouse.InternalAggregation,
+			ConsolidateBy: consolidateBy,
 		}
 
-		if index > 0 {
-			listBuf.WriteByte(',')
+		// carbonlink cache contains the latest points, merge them to the last table only
+		carbonlinkRead := noCarbonlink
+		if i == len(tables)-1 {
+			carbonlinkRead = carbonlinkResponseRead
 		}
 
-		if isReverse {
-			listBuf.WriteString("'" + clickhouse.Escape(reversePath(unsafeString(m))) + "'")
-		} else {
-			listBuf.WriteString("'" + clickhouse.Escape(unsafeString(m)) + "'")
+		if err := h.fetchTable(ctx, t, data, metricList, metricsAggr, carbonlinkRead, collect); err != nil {
+			return err
 		}
 	}
 
-	if listBuf.Len() == 0 {
-		// Return empty response
-		h.Reply(w, r, EmptyData, 0, 0, "", nil)
-		return
+	keys := make([]seriesKey, 0, len(parts))
+	for key := range parts {
+		keys = append(keys, key)
 	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].pathExpression < keys[j].pathExpression
+	})
 
-	preWhere := finder.NewWhere()
-	preWhere.Andf(
-		"Date >='%s' AND Date <= '%s'",
-		time.Unix(fromTimestamp, 0).Format("2006-01-02"),
-		time.Unix(untilTimestamp, 0).Format("2006-01-02"),
-	)
+	for _, key := range keys {
+		out.write(stitchSeries(parts[key], uint32(fromTimestamp), uint32(untilTimestamp), fetchRequest.MaxDataPoints))
+	}
 
-	where := finder.NewWhere()
-	where.Andf("Path in (%s)", listBuf.String())
-
-	until := untilTimestamp - untilTimestamp%int64(maxStep) + int64(maxStep) - 1
-	where.Andf("Time >= %d AND Time <= %d", fromTimestamp, until)
-
-	query := fmt.Sprintf(
-		`
-		SELECT
-			Path, Time, Value, Timestamp
-		FROM %s
-		PREWHERE (%s)
-		WHERE (%s)
-		FORMAT RowBinary
-		`,
-		pointsTable,
-		preWhere.String(),
-		where.String(),
-	)
+	return nil
+}
 
-	// start carbonlink request
-	carbonlinkResponseRead := h.queryCarbonlink(r.Context(), logger, metricList)
-
-	body, err := clickhouse.Reader(
-		r.Context(),
-		h.config.ClickHouse.Url,
-		query,
-		pointsTable,
-		clickhouse.Options{Timeout: h.config.ClickHouse.DataTimeout.Value(), ConnectTimeout: h.config.ClickHouse.ConnectTimeout.Value()},
+// fetchTable reads points of metricList in time range of data table t and writes series rolled up by data to write
+func (h *Handler) fetchTable(
+	ctx context.Context,
+	t *DataTableRange,
+	data *Data,
+	metricList [][]byte,
+	metricsAggr map[string]*rollup.Aggr,
+	carbonlinkResponseRead func() *point.Points,
+	write func(s *series),
+) error {
+	logger := log.FromContext(ctx)
+
+	groups := groupMetrics(
+		metricList, data.Rollup, uint32(t.From), t.Reverse, data.Aggregated,
+		h.config.ClickHouse.DataChunkSize, metricsAggr,
 	)
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if len(groups) == 0 {
+		// Nothing to reply
+		return nil
 	}
 
-	// fetch carbonlink response
-	carbonlinkData := carbonlinkResponseRead()
+	// mu serializes carbonlink points access and writes to reply from concurrent chunks
+	var mu sync.Mutex
+	var carbonlinkPoints map[string][]point.Point
+	var streamTime time.Duration
+
+	err := fetchGroups(ctx, groups, h.config.ClickHouse.DataMaxConcurrent, func(ctx context.Context, g *metricsGroup) error {
+		body, err := clickhouse.ReaderExternal(
+			ctx,
+			t.Url,
+			dataQuery(t.Table, t.Schema, g, t.From, t.Until),
+			t.Table,
+			g.paths,
+			t.Options,
+		)
 
-	parseStart := time.Now()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
 
-	// pass carbonlinkData to DataParse
-	data, err := DataParse(body, carbonlinkData, isReverse)
+		mu.Lock()
+		if carbonlinkPoints == nil {
+			// fetch carbonlink response
+			carbonlinkPoints = splitByMetric(carbonlinkResponseRead())
+		}
+		mu.Unlock()
+
+		streamStart := time.Now()
+		err = DataStream(body, t.Reverse, func(metricName string, points []point.Point) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			points = mergePoints(points, carbonlinkPoints[metricName])
+			delete(carbonlinkPoints, metricName)
+
+			data.metricSeries(metricName, points, write)
+			return nil
+		})
+
+		mu.Lock()
+		streamTime += time.Since(streamStart)
+		mu.Unlock()
+
+		return err
+	})
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
 
-	d := time.Since(parseStart)
-	logger.Debug("parse", zap.String("runtime", d.String()), zap.Duration("runtime_ns", d))
+	// series found only in carbonlink cache
+	for metricName, points := range carbonlinkPoints {
+		data.metricSeries(metricName, mergePoints(points, nil), write)
+	}
+
+	logger.Debug("stream", zap.String("table", t.Table), zap.String("runtime", streamTime.String()), zap.Duration("runtime_ns", streamTime))
+
+	return nil
+}
+
+// fetchGroups calls fetch for every group with at most concurrency parallel calls.
+// The first error cancels context of all running calls and is returned
+func fetchGroups(ctx context.Context, groups []*metricsGroup, concurrency int, fetch func(ctx context.Context, g *metricsGroup) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	sortStart := time.Now()
-	data.Points.Sort()
-	d = time.Since(sortStart)
-	logger.Debug("sort", zap.String("runtime", d.String()), zap.Duration("runtime_ns", d))
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
 
-	data.Points.Uniq()
-	data.Aliases = aliases
+	sem := make(chan struct{}, concurrency)
 
-	// pp.Println(points)
-	h.Reply(w, r, data, uint32(fromTimestamp), uint32(untilTimestamp), prefix, rollupObj)
+	for _, g := range groups {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(g *metricsGroup) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := fetch(ctx, g); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(g)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
 }
 
-func (h *Handler) Reply(w http.ResponseWriter, r *http.Request, data *Data, from, until uint32, prefix string, rollupObj *rollup.Rollup) {
-	start := time.Now()
-	switch r.FormValue("format") {
-	case "pickle":
-		h.ReplyPickle(w, r, data, from, until, prefix, rollupObj)
-	case "protobuf":
-		h.ReplyProtobuf(w, r, data, from, until, prefix, rollupObj)
+// metricsConsolidateBy returns consolidation functions of metrics which are requested by targets with the same consolidateBy.
+// Metrics of targets with different functions are aggregated by clickhouse with rollup function
+func metricsConsolidateBy(aliases map[string][]string, consolidateBy map[string]*rollup.Aggr) map[string]*rollup.Aggr {
+	result := make(map[string]*rollup.Aggr)
+	if len(consolidateBy) == 0 {
+		return result
+	}
+
+	for metric, a := range aliases {
+		aggr := consolidateBy[a[1]]
+		for k := 3; k < len(a); k += 2 {
+			if consolidateBy[a[k]] != aggr {
+				aggr = nil
+				break
+			}
+		}
+
+		if aggr != nil {
+			result[metric] = aggr
+		}
 	}
-	d := time.Since(start)
-	log.FromContext(r.Context()).Debug("reply", zap.String("runtime", d.String()), zap.Duration("runtime_ns", d))
+
+	return result
 }
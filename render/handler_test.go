@@ -0,0 +1,414 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/lomik/graphite-clickhouse/config"
+	"github.com/lomik/graphite-clickhouse/helper/cache"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+type clickhouseMock struct {
+	tree    string            // response for find queries
+	data    []byte            // response for data queries
+	tables  map[string][]byte // responses for data queries by table
+	queries []string
+	ext     []byte // external data of last query
+}
+
+func (m *clickhouseMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		// query with external data
+		query = r.URL.Query().Get("query")
+		if f, _, err := r.FormFile("_ext"); err == nil {
+			m.ext, _ = ioutil.ReadAll(f)
+		}
+	} else {
+		body, _ := ioutil.ReadAll(r.Body)
+		query = string(body)
+		if query == "" {
+			query = r.URL.Query().Get("query")
+		}
+	}
+	m.queries = append(m.queries, query)
+
+	if strings.Contains(query, "FORMAT RowBinary") {
+		for table, data := range m.tables {
+			if strings.Contains(query, "FROM "+table+"\n") {
+				w.Write(data)
+				return
+			}
+		}
+		w.Write(m.data)
+		return
+	}
+
+	w.Write([]byte(m.tree))
+}
+
+func newTestConfig(t *testing.T, url string) *config.Config {
+	cfg := config.New()
+	cfg.ClickHouse.Url = url
+
+	r, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Rollup = r
+
+	return cfg
+}
+
+// newTestHandler returns render handler with config of clickhouse mock changed by configure (may be nil)
+// and function to stop mock server
+func newTestHandler(t *testing.T, m *clickhouseMock, configure func(cfg *config.Config)) (*Handler, func()) {
+	srv := httptest.NewServer(m)
+
+	cfg := newTestConfig(t, srv.URL)
+	if configure != nil {
+		configure(cfg)
+	}
+
+	return NewHandler(cfg), srv.Close
+}
+
+// testRender serves render request with url
+func testRender(h *Handler, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", url, nil)
+	r = r.WithContext(context.WithValue(r.Context(), "logger", zap.NewNop()))
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerStream(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &clickhouseMock{
+		tree: "a.b\na.c\n",
+		data: makeData([]testPoint{
+			{"a.b", 1, 1520056680, 1520056680},
+			{"a.b", 2, 1520056740, 1520056740},
+			{"a.c", 3, 1520056740, 1520056740},
+		}),
+	}
+	h, stop := newTestHandler(t, m, nil)
+	defer stop()
+
+	w := testRender(h, "/render/?format=json&from=1520056680&until=1520056799&target=a.*")
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(
+		`[{"target":"a.b","datapoints":[[1,1520056680],[2,1520056740]]},{"target":"a.c","datapoints":[[null,1520056680],[3,1520056740]]}]`,
+		w.Body.String(),
+	)
+	assert.Contains(m.queries[len(m.queries)-1], "ORDER BY Path, Time")
+	assert.Contains(m.queries[len(m.queries)-1], "Path IN _ext")
+	// metrics are sent in any order
+	assert.Len(m.ext, 8)
+	assert.Contains(string(m.ext), "\x03a.b")
+	assert.Contains(string(m.ext), "\x03a.c")
+}
+
+func TestFetchGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	groups := make([]*metricsGroup, 10)
+	for i := 0; i < len(groups); i++ {
+		groups[i] = newMetricsGroup(60, nil)
+	}
+
+	var running, maxRunning, calls int32
+	err := fetchGroups(context.Background(), groups, 3, func(ctx context.Context, g *metricsGroup) error {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal(int32(10), calls)
+	assert.True(maxRunning <= 3)
+
+	// first error cancels other chunks
+	fail := errors.New("fail")
+	calls = 0
+	err = fetchGroups(context.Background(), groups, 2, func(ctx context.Context, g *metricsGroup) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return fail
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	assert.Equal(fail, err)
+	assert.True(calls < 10)
+}
+
+func TestHandlerRenderCache(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &clickhouseMock{
+		tree: "a.b\n",
+		data: makeData([]testPoint{
+			{"a.b", 1, 1520056680, 1520056680},
+		}),
+	}
+	h, stop := newTestHandler(t, m, func(cfg *config.Config) {
+		cfg.RenderCache.Cache = cache.New(1024 * 1024)
+	})
+	defer stop()
+
+	render := func(url string) string {
+		w := testRender(h, url)
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal("application/json", w.Header().Get("Content-Type"))
+		return w.Body.String()
+	}
+
+	expected := `[{"target":"a.b","datapoints":[[1,1520056680]]}]`
+
+	assert.Equal(expected, render("/render/?format=json&from=1520056680&until=1520056739&target=a.*"))
+	assert.Len(m.queries, 2)
+
+	// until is rounded to the same rollup step
+	assert.Equal(expected, render("/render/?format=json&from=1520056680&until=1520056735&target=a.*"))
+	assert.Len(m.queries, 2)
+
+	assert.Equal(expected, render("/render/?format=json&from=1520056680&until=1520056739&target=a.*&noCache=1"))
+	assert.Len(m.queries, 4)
+}
+
+func TestHandlerLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &clickhouseMock{
+		tree: "a.b\na.c\n",
+		data: makeData([]testPoint{
+			{"a.b", 1, 1520056680, 1520056680},
+		}),
+	}
+	table := []struct {
+		limits config.Limits
+		status int
+		body   string
+	}{
+		{config.Limits{}, http.StatusOK, ""},
+		{config.Limits{MaxSeriesPerTarget: 1}, http.StatusBadRequest, "max-series-per-target limit exceeded: 2 > 1\n"},
+		{config.Limits{MaxSeries: 1}, http.StatusBadRequest, "max-series limit exceeded: 2 > 1\n"},
+		{config.Limits{MaxPoints: 3}, http.StatusBadRequest, "max-points limit exceeded: 4 > 3\n"},
+		{config.Limits{MaxPoints: 4}, http.StatusOK, ""},
+	}
+
+	for _, test := range table {
+		m.queries = nil
+		h, stop := newTestHandler(t, m, func(cfg *config.Config) {
+			cfg.Limits = test.limits
+		})
+		w := testRender(h, "/render/?format=json&from=1520056680&until=1520056799&target=a.*")
+		stop()
+
+		assert.Equal(test.status, w.Code)
+		if test.body != "" {
+			assert.Equal(test.body, w.Body.String())
+		}
+
+		// find query fetches one row more than limit
+		if test.limits.MaxSeriesPerTarget > 0 {
+			assert.True(strings.HasSuffix(m.queries[0], fmt.Sprintf(" LIMIT %d", test.limits.MaxSeriesPerTarget+1)), m.queries[0])
+		} else {
+			assert.NotContains(m.queries[0], "LIMIT")
+		}
+	}
+}
+
+func TestHandlerConsolidateBy(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &clickhouseMock{
+		tree: "a.b\n",
+		data: makeData([]testPoint{
+			{"a.b", 1, 1520056680, 1520056680},
+			{"a.b", 3, 1520056700, 1520056700},
+		}),
+	}
+	h, stop := newTestHandler(t, m, nil)
+	defer stop()
+
+	// rollup function
+	assert.Equal(
+		`[{"target":"a.b","datapoints":[[2,1520056680]]}]`,
+		testRender(h, "/render/?format=json&from=1520056680&until=1520056739&target=a.*").Body.String(),
+	)
+
+	// consolidateBy replaces rollup function
+	assert.Equal(
+		`[{"target":"a.b","datapoints":[[3,1520056680]]}]`,
+		testRender(h, "/render/?format=json&from=1520056680&until=1520056739&target=a.*&consolidateBy=max").Body.String(),
+	)
+}
+
+func TestMetricsConsolidateBy(t *testing.T) {
+	assert := assert.New(t)
+
+	aliases := map[string][]string{
+		"a.b": {"a.b", "a.*"},
+		"a.c": {"a.c", "a.*", "a.c", "*.c"},
+		"b.c": {"b.c", "*.c"},
+	}
+
+	max := consolidateAggr("max")
+	result := metricsConsolidateBy(aliases, map[string]*rollup.Aggr{"a.*": max})
+	assert.Equal(map[string]*rollup.Aggr{"a.b": max}, result)
+
+	assert.Len(metricsConsolidateBy(aliases, nil), 0)
+}
+
+func TestHandlerStitchDataTables(t *testing.T) {
+	assert := assert.New(t)
+
+	now := uint32(time.Now().Unix())
+	from := now - 7200 - now%300
+	// the first point of recent table rolled up to 300 seconds
+	recent := now - 3600 - now%300 + 300
+
+	m := &clickhouseMock{
+		tree: "a.b\n",
+		tables: map[string][]byte{
+			"graphite_archive": makeData([]testPoint{
+				{"a.b", 1, from, from},
+				{"a.b", 2, from + 300, from + 300},
+			}),
+			"graphite": makeData([]testPoint{
+				{"a.b", 4, recent, recent},
+				{"a.b", 6, recent + 60, recent + 60},
+			}),
+		},
+	}
+	archiveRollup, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>300</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	h, stop := newTestHandler(t, m, func(cfg *config.Config) {
+		cfg.ClickHouse.StitchDataTables = true
+		cfg.DataTable = []config.DataTable{
+			{Table: "graphite_archive", MinAge: &config.Duration{Duration: time.Hour}, Rollup: archiveRollup},
+		}
+	})
+	defer stop()
+
+	w := testRender(h, fmt.Sprintf("/render/?format=json&from=%d&until=%d&target=a.*", from, now))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), fmt.Sprintf("[1,%d],[2,%d],[null,%d]", from, from+300, from+600))
+	assert.Contains(w.Body.String(), fmt.Sprintf("[5,%d]", recent))
+	assert.Len(m.queries, 3)
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	assert := assert.New(t)
+
+	// glob a.* matches default rule, but resolved metric a.b is rolled up to 600 seconds
+	r, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<pattern>
+ 		<regexp>^a\.b$</regexp>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>600</precision>
+ 		</retention>
+ 	</pattern>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	cfg := newTestConfig(t, "http://localhost:8123")
+	cfg.Rollup = r
+	h := NewHandler(cfg)
+
+	now := time.Now().Unix()
+	from := now - 3600 - now%600
+
+	key := func(until int64) string {
+		return h.renderCacheKey("json", MultiFetchRequest{
+			{TimeFrame: TimeFrame{From: from, Until: until}, Targets: []Target{{Name: "a.*"}}},
+		})
+	}
+
+	// reply of a.b is the same within 600 seconds step
+	assert.Equal(key(from+100), key(from+500))
+	assert.NotEqual(key(from+100), key(from+700))
+
+	// stitched reply depends on rollup of every table
+	archiveRollup, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>1800</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	single := key(now)
+	cfg.DataTable = []config.DataTable{
+		{Table: "graphite_archive", MinAge: &config.Duration{Duration: 30 * time.Minute}, Rollup: archiveRollup},
+	}
+	cfg.ClickHouse.StitchDataTables = true
+	stitched := key(now)
+	assert.NotEqual(single, stitched)
+	assert.Contains(stitched, ";graphite_archive@1800;graphite@600;")
+	assert.Contains(stitched, fmt.Sprintf(";%d;%d;", from-from%1800, now-now%1800))
+}
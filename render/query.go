@@ -0,0 +1,186 @@
+package render
+
+import (
+	"fmt"
+
+	"github.com/lomik/graphite-clickhouse/finder"
+	"github.com/lomik/graphite-clickhouse/helper/RowBinary"
+	"github.com/lomik/graphite-clickhouse/helper/clickhouse"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
+)
+
+// pathTable is the name of external table with metric list of query
+const pathTable = "_ext"
+
+// metricsGroup is a list of metrics selected by one query
+type metricsGroup struct {
+	step         uint32       // max rollup precision of metrics
+	aggr         *rollup.Aggr // aggregate function for clickhouse-side rollup. nil for raw points
+	xFilesFactor float64      // min ratio of known points in rolled up interval for clickhouse-side rollup
+	expected     string       // expression of expected points count in rolled up interval
+	paths        *clickhouse.ExternalData
+	encoder      *RowBinary.Encoder
+	metrics      int
+}
+
+func newMetricsGroup(step uint32, aggr *rollup.Aggr) *metricsGroup {
+	paths := clickhouse.NewExternalData(pathTable, "Path String", "RowBinary")
+	return &metricsGroup{
+		step:    step,
+		aggr:    aggr,
+		paths:   paths,
+		encoder: RowBinary.NewEncoder(paths),
+	}
+}
+
+func (g *metricsGroup) add(path string) {
+	g.encoder.String(path)
+	g.metrics++
+}
+
+// groupMetrics splits metric list to query groups.
+// Without aggregation all metrics are selected by one query with max step.
+// With aggregation metrics are grouped by rollup precision and function,
+// consolidateBy function of metric replaces rollup function.
+// Groups with more than chunkSize metrics are split to chunks. chunkSize <= 0 means no limit
+func groupMetrics(metricList [][]byte, rollupObj *rollup.Rules, from uint32, isReverse bool, aggregate bool, chunkSize int, consolidateBy map[string]*rollup.Aggr) []*metricsGroup {
+	groups := make([]*metricsGroup, 0)
+	index := make(map[string]*metricsGroup)
+
+	for _, m := range metricList {
+		if len(m) == 0 {
+			continue
+		}
+
+		step, rule := rollupObj.Lookup(unsafeString(m), from)
+
+		var aggr *rollup.Aggr
+		var xFilesFactor float64
+		var expected string
+
+		key := ""
+		if aggregate {
+			aggr = consolidateBy[unsafeString(m)]
+			if aggr == nil {
+				aggr = rule.Aggr()
+			}
+			xFilesFactor = rule.XFilesFactor
+			if xFilesFactor > 0 {
+				expected = rule.PointsPerIntervalSQL(step, "RoundTime")
+			}
+			key = fmt.Sprintf("%d:%s:%g:%s", step, aggr.Name(), xFilesFactor, expected)
+		}
+
+		g := index[key]
+		if g == nil || (chunkSize > 0 && g.metrics >= chunkSize) {
+			g = newMetricsGroup(step, aggr)
+			g.xFilesFactor = xFilesFactor
+			g.expected = expected
+			index[key] = g
+			groups = append(groups, g)
+		}
+
+		// without aggregation group contains metrics with different precisions
+		if step > g.step {
+			g.step = step
+		}
+
+		if isReverse {
+			g.add(reversePath(unsafeString(m)))
+		} else {
+			g.add(unsafeString(m))
+		}
+	}
+
+	return groups
+}
+
+// estimatePoints returns number of points of metrics in time range [from, until] rolled up with their precisions
+func estimatePoints(metricList [][]byte, rollupObj *rollup.Rules, from, until uint32) int64 {
+	var points int64
+	if until < from {
+		return points
+	}
+
+	for _, m := range metricList {
+		step := rollupObj.Step(unsafeString(m), from)
+		if step == 0 {
+			step = 1
+		}
+		points += int64((until-from)/step + 1)
+	}
+	return points
+}
+
+// dataQuery returns query for points of metrics group in time range [from, until] sorted by Path and Time.
+// Metric list is not included to query and must be sent as external data g.paths
+func dataQuery(table string, s *schema.Schema, g *metricsGroup, from, until int64) string {
+	// table without Date column is queried without PREWHERE
+	dateWhere := finder.NewWhere()
+	dateWhere.And(s.DateWhere(from, until))
+
+	preWhere := ""
+	if dateWhere.String() != "" {
+		preWhere = fmt.Sprintf("PREWHERE (%s)", dateWhere)
+	}
+
+	where := finder.NewWhere()
+	where.Andf("%s IN %s", s.Path, pathTable)
+
+	step := int64(g.step)
+	until = until - until%step + step - 1
+	where.Andf("%[1]s >= %[2]d AND %[1]s <= %[3]d", s.Time, from, until)
+
+	if g.aggr == nil {
+		return fmt.Sprintf(
+			`
+			SELECT
+				%[1]s, %[2]s, %[3]s, %[4]s
+			FROM %[5]s
+			%[6]s
+			WHERE (%[7]s)
+			ORDER BY %[1]s, %[2]s
+			FORMAT RowBinary
+			`,
+			s.Path, s.Time, s.Value, s.Timestamp,
+			table,
+			preWhere,
+			where.String(),
+		)
+	}
+
+	// intervals with too few known points are skipped
+	having := ""
+	if g.xFilesFactor > 0 {
+		having = fmt.Sprintf("HAVING count() / %s >= %g", g.expected, g.xFilesFactor)
+	}
+
+	// inner query deduplicates points by max Timestamp, outer one rolls up them with precision step.
+	// Inner aliases differ from column names: ClickHouse resolves aliases first, so max(...) AS Timestamp
+	// would turn argMax(Value, Timestamp) into nested aggregate function
+	return fmt.Sprintf(
+		`
+		SELECT
+			%[1]s, toUInt32(intDiv(%[2]s, %[5]d) * %[5]d) AS RoundTime, %[6]s AS AggValue, max(DedupTs) AS MaxTimestamp
+		FROM (
+			SELECT
+				%[1]s, %[2]s, argMax(%[3]s, %[4]s) AS DedupValue, max(%[4]s) AS DedupTs
+			FROM %[7]s
+			%[8]s
+			WHERE (%[9]s)
+			GROUP BY %[1]s, %[2]s
+		)
+		GROUP BY %[1]s, RoundTime
+		%[10]s
+		ORDER BY %[1]s, RoundTime
+		FORMAT RowBinary
+		`,
+		s.Path, s.Time, s.Value, s.Timestamp,
+		step, g.aggr.SQL("DedupValue", s.Time, "DedupTs"),
+		table,
+		preWhere,
+		where.String(),
+		having,
+	)
+}
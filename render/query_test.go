@@ -0,0 +1,199 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+	"github.com/lomik/graphite-clickhouse/helper/schema"
+)
+
+func formatSQL(q string) string {
+	return strings.Join(strings.Fields(q), " ")
+}
+
+func TestDataQueryAggregated(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<pattern>
+ 		<regexp>^sum\.</regexp>
+ 		<function>sum</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>10</precision>
+ 		</retention>
+ 	</pattern>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	metrics := [][]byte{[]byte("sum.a"), []byte("avg.b"), []byte("sum.c")}
+
+	groups := groupMetrics(metrics, rollupObj.Rules(), 1520056680, false, false, 0, nil)
+	assert.Len(groups, 1)
+	assert.Equal(uint32(60), groups[0].step)
+	assert.Equal(3, groups[0].metrics)
+	assert.Equal(
+		"\x05sum.a\x05avg.b\x05sum.c",
+		string(groups[0].paths.Bytes()),
+	)
+	assert.Equal(
+		"SELECT Path, Time, Value, Timestamp FROM graphite PREWHERE ((Date >='2018-03-03' AND Date <= '2018-03-03')) WHERE ((Path IN _ext) AND (Time >= 1520056680 AND Time <= 1520056799)) ORDER BY Path, Time FORMAT RowBinary",
+		formatSQL(dataQuery("graphite", schema.Default(), groups[0], 1520056680, 1520056740)),
+	)
+
+	groups = groupMetrics(metrics, rollupObj.Rules(), 1520056680, false, true, 0, nil)
+	assert.Len(groups, 2, nil)
+	assert.Equal(
+		"SELECT Path, toUInt32(intDiv(Time, 10) * 10) AS RoundTime, sum(DedupValue) AS AggValue, max(DedupTs) AS MaxTimestamp FROM ( "+
+			"SELECT Path, Time, argMax(Value, Timestamp) AS DedupValue, max(Timestamp) AS DedupTs FROM graphite "+
+			"PREWHERE ((Date >='2018-03-03' AND Date <= '2018-03-03')) WHERE ((Path IN _ext) AND (Time >= 1520056680 AND Time <= 1520056749)) "+
+			"GROUP BY Path, Time ) GROUP BY Path, RoundTime ORDER BY Path, RoundTime FORMAT RowBinary",
+		formatSQL(dataQuery("graphite", schema.Default(), groups[0], 1520056680, 1520056740)),
+	)
+	assert.Equal(uint32(60), groups[1].step)
+	assert.Equal("avg", groups[1].aggr.Name())
+}
+
+func TestGroupMetricsChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	metrics := [][]byte{[]byte("a.1"), []byte("a.2"), []byte("a.3"), []byte("a.4"), []byte("a.5")}
+
+	groups := groupMetrics(metrics, rollupObj.Rules(), 1520056680, false, false, 2, nil)
+	assert.Len(groups, 3)
+	assert.Equal(2, groups[0].metrics)
+	assert.Equal(2, groups[1].metrics)
+	assert.Equal(1, groups[2].metrics)
+	assert.Equal("\x03a.5", string(groups[2].paths.Bytes()))
+	for _, g := range groups {
+		assert.Equal(uint32(60), g.step)
+	}
+
+	groups = groupMetrics(metrics, rollupObj.Rules(), 1520056680, false, true, 0, nil)
+	assert.Len(groups, 1)
+	assert.Equal(5, groups[0].metrics)
+}
+
+func TestDataQueryXFilesFactor(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<xFilesFactor>0.5</xFilesFactor>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>10</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	groups := groupMetrics([][]byte{[]byte("a.b")}, rollupObj.Rules(), 1520056680, false, true, 0, nil)
+	assert.Len(groups, 1)
+	assert.Contains(
+		formatSQL(dataQuery("graphite", schema.Default(), groups[0], 1520056680, 1520056740)),
+		"GROUP BY Path, RoundTime HAVING count() / 1 >= 0.5 ORDER BY Path, RoundTime",
+	)
+
+	// points older than the first retention are counted with precision they are stored at
+	rollupObj, err = rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<xFilesFactor>0.5</xFilesFactor>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 		<retention>
+ 			<age>86400</age>
+ 			<precision>3600</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	groups = groupMetrics([][]byte{[]byte("a.b")}, rollupObj.Rules(), 1520056680, false, true, 0, nil)
+	assert.Len(groups, 1)
+	assert.Equal(uint32(3600), groups[0].step)
+	assert.Regexp(
+		`GROUP BY Path, RoundTime HAVING count\(\) / multiIf\(RoundTime < \d+, 1, 60\) >= 0.5 ORDER BY Path, RoundTime`,
+		formatSQL(dataQuery("graphite", schema.Default(), groups[0], 1520056680, 1520060280)),
+	)
+}
+
+func TestGroupMetricsConsolidateBy(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	metrics := [][]byte{[]byte("a.b"), []byte("a.c")}
+	groups := groupMetrics(metrics, rollupObj.Rules(), 1520056680, false, true, 0, map[string]*rollup.Aggr{
+		"a.c": rollup.GetAggr("max"),
+	})
+	assert.Len(groups, 2)
+	assert.Equal("avg", groups[0].aggr.Name())
+	assert.Equal("max", groups[1].aggr.Name())
+}
+
+func TestDataQuerySchema(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &schema.Schema{Path: "Metric", Time: "Ts", Value: "Val", NoDate: true}
+	s.SetDefaults()
+
+	g := newMetricsGroup(60, nil)
+	assert.Equal(
+		"SELECT Metric, Ts, Val, Timestamp FROM graphite WHERE ((Metric IN _ext) AND (Ts >= 1520056680 AND Ts <= 1520056799)) ORDER BY Metric, Ts FORMAT RowBinary",
+		formatSQL(dataQuery("graphite", s, g, 1520056680, 1520056740)),
+	)
+
+	g = newMetricsGroup(60, rollup.GetAggr("max"))
+	assert.Equal(
+		"SELECT Metric, toUInt32(intDiv(Ts, 60) * 60) AS RoundTime, max(DedupValue) AS AggValue, max(DedupTs) AS MaxTimestamp FROM ( "+
+			"SELECT Metric, Ts, argMax(Val, Timestamp) AS DedupValue, max(Timestamp) AS DedupTs FROM graphite "+
+			"WHERE ((Metric IN _ext) AND (Ts >= 1520056680 AND Ts <= 1520056799)) "+
+			"GROUP BY Metric, Ts ) GROUP BY Metric, RoundTime ORDER BY Metric, RoundTime FORMAT RowBinary",
+		formatSQL(dataQuery("graphite", s, g, 1520056680, 1520056740)),
+	)
+}
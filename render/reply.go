@@ -0,0 +1,146 @@
+package render
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+// series is rolled up points of one metric requested by one target
+type series struct {
+	name           string        // metric name for reply
+	pathExpression string        // requested target
+	points         []point.Point // rolled up points sorted by time
+	step           uint32
+	from           uint32       // requested time range
+	until          uint32       // requested time range
+	aggr           *rollup.Aggr // consolidation function
+	xFilesFactor   float64
+}
+
+// bounds returns timestamps of the first and the last values of series
+func (s *series) bounds() (uint32, uint32) {
+	start := s.from - (s.from % s.step)
+	if start < s.from {
+		start += s.step
+	}
+	stop := s.until - (s.until % s.step)
+	return start, stop
+}
+
+// count returns number of values between bounds. Zero if time range is shorter than step
+func (s *series) count() uint32 {
+	start, stop := s.bounds()
+	if stop < start {
+		return 0
+	}
+	return ((stop - start) / s.step) + 1
+}
+
+// replyWriter writes series in one of render formats
+type replyWriter interface {
+	contentType() string
+	begin()
+	write(s *series)
+	end()
+}
+
+func newReplyWriter(format string, w *bufio.Writer) replyWriter {
+	switch format {
+	case "pickle":
+		return newPickleWriter(w)
+	case "protobuf":
+		return &protobufWriter{w: w}
+	case "carbonapi_v3_pb":
+		return &protobufV3Writer{w: w}
+	case "json":
+		return &jsonWriter{w: w}
+	}
+	return nil
+}
+
+// countWriter counts bytes sent to client
+type countWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// reply streams series to client
+type reply struct {
+	w        http.ResponseWriter
+	counter  *countWriter
+	buf      *bufio.Writer
+	writer   replyWriter
+	begun    bool
+	duration time.Duration
+}
+
+func newReply(w http.ResponseWriter, format string) *reply {
+	counter := &countWriter{w: w}
+	buf := bufio.NewWriterSize(counter, 1024*1024)
+
+	return &reply{
+		w:       w,
+		counter: counter,
+		buf:     buf,
+		writer:  newReplyWriter(format, buf),
+	}
+}
+
+// tee copies reply body to buf
+func (r *reply) tee(buf io.Writer) {
+	r.counter.w = io.MultiWriter(r.w, buf)
+}
+
+func (r *reply) begin() {
+	if r.begun || r.writer == nil {
+		return
+	}
+	r.begun = true
+	r.w.Header().Set("Content-Type", r.writer.contentType())
+	r.writer.begin()
+}
+
+func (r *reply) write(s *series) {
+	if r.writer == nil {
+		return
+	}
+	start := time.Now()
+	r.begin()
+	r.writer.write(s)
+	r.duration += time.Since(start)
+}
+
+// end completes and flushes reply
+func (r *reply) end() {
+	if r.writer == nil {
+		return
+	}
+	r.begin()
+	r.writer.end()
+	r.buf.Flush()
+}
+
+// fail replies error if nothing was sent to client yet. Otherwise logs error and aborts response
+func (r *reply) fail(logger *zap.Logger, err error, status int) {
+	if r.counter.written == 0 {
+		r.buf.Reset(r.counter)
+		r.w.Header().Del("Content-Type")
+		http.Error(r.w, err.Error(), status)
+		return
+	}
+	logger.Error("reply aborted", zap.Error(err), zap.Int("status", status), zap.Int64("written", r.counter.written))
+	panic(http.ErrAbortHandler)
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// jsonWriter writes graphite-web compatible json:
+// [{"target": "name", "datapoints": [[value, timestamp], ...]}, ...]
+type jsonWriter struct {
+	w           *bufio.Writer
+	buf         []byte // reusable buffer for number formatting
+	firstSeries bool
+	firstValue  bool
+}
+
+func (jw *jsonWriter) contentType() string {
+	return "application/json"
+}
+
+func (jw *jsonWriter) begin() {
+	jw.firstSeries = true
+	jw.w.WriteByte('[')
+}
+
+func (jw *jsonWriter) writeValue(value float64, isAbsent bool, timestamp uint32) {
+	writer := jw.w
+
+	if !jw.firstValue {
+		writer.WriteByte(',')
+	}
+	jw.firstValue = false
+
+	writer.WriteByte('[')
+	if isAbsent || math.IsNaN(value) || math.IsInf(value, 0) {
+		writer.WriteString("null")
+	} else {
+		jw.buf = strconv.AppendFloat(jw.buf[:0], value, 'f', -1, 64)
+		writer.Write(jw.buf)
+	}
+	writer.WriteByte(',')
+	jw.buf = strconv.AppendUint(jw.buf[:0], uint64(timestamp), 10)
+	writer.Write(jw.buf)
+	writer.WriteByte(']')
+}
+
+func (jw *jsonWriter) write(s *series) {
+	writer := jw.w
+	step := s.step
+
+	start, end := s.bounds()
+
+	if !jw.firstSeries {
+		writer.WriteByte(',')
+	}
+	jw.firstSeries = false
+	jw.firstValue = true
+
+	nameJSON, _ := json.Marshal(s.name)
+	writer.WriteString(`{"target":`)
+	writer.Write(nameJSON)
+	writer.WriteString(`,"datapoints":[`)
+
+	ts := start
+	for _, point := range s.points {
+		if point.Time < start || point.Time > end {
+			continue
+		}
+
+		for ; ts < point.Time; ts += step {
+			jw.writeValue(0, true, ts)
+		}
+
+		jw.writeValue(point.Value, false, point.Time)
+		ts = point.Time + step
+	}
+
+	for ; ts <= end; ts += step {
+		jw.writeValue(0, true, ts)
+	}
+
+	writer.WriteString("]}")
+}
+
+func (jw *jsonWriter) end() {
+	jw.w.WriteByte(']')
+}
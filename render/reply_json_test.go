@@ -0,0 +1,83 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+func TestReplyJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>avg</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	from := uint32(1520056680)
+	until := from + 180
+
+	t.Run("empty", func(t *testing.T) {
+		w := testReply("json", &Data{}, nil)
+
+		assert.Equal("application/json", w.Header().Get("Content-Type"))
+		assert.Equal("[]", w.Body.String())
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		points := []testPoint{
+			{"hello.world", 1, from + 1, from + 1},
+			{"hello.world", 3, from + 2, from + 2},
+			{"hello.world", 4.5, from + 120, from + 120},
+		}
+
+		data := &Data{
+			Aliases: map[string][]string{"hello.world": {"hello.world", "hello.*"}},
+			From:    from,
+			Until:   until,
+			Rollup:  rollupObj.Rules(),
+		}
+
+		w := testReply("json", data, points)
+
+		assert.Equal(
+			`[{"target":"hello.world","datapoints":[[2,1520056680],[null,1520056740],[4.5,1520056800],[null,1520056860]]}]`,
+			w.Body.String(),
+		)
+	})
+
+	t.Run("maxDataPoints", func(t *testing.T) {
+		points := []testPoint{
+			{"hello.world", 1, from + 1, from + 1},
+			{"hello.world", 3, from + 60, from + 60},
+			{"hello.world", 4.5, from + 120, from + 120},
+			{"hello.world", 2, from + 180, from + 180},
+		}
+
+		data := &Data{
+			Aliases:       map[string][]string{"hello.world": {"hello.world", "hello.*"}},
+			From:          from,
+			Until:         until,
+			Rollup:        rollupObj.Rules(),
+			MaxDataPoints: 2,
+			ConsolidateBy: map[string]*rollup.Aggr{"hello.*": consolidateAggr("max")},
+		}
+
+		w := testReply("json", data, points)
+
+		assert.Equal(
+			`[{"target":"hello.world","datapoints":[[3,1520056680],[4.5,1520056800]]}]`,
+			w.Body.String(),
+		)
+	})
+}
@@ -1,126 +1,80 @@
 package render
 
 import (
-	"bufio"
-	"net/http"
-	"time"
+	"io"
 
-	"github.com/lomik/graphite-clickhouse/helper/log"
-	"github.com/lomik/graphite-clickhouse/helper/point"
-	"github.com/lomik/graphite-clickhouse/helper/rollup"
 	pickle "github.com/lomik/graphite-pickle"
-	"go.uber.org/zap"
 )
 
-func (h *Handler) ReplyPickle(w http.ResponseWriter, r *http.Request, data *Data, from, until uint32, prefix string, rollupObj *rollup.Rollup) {
-	var rollupTime time.Duration
-	var pickleTime time.Duration
-
-	points := data.Points.List()
-
-	defer func() {
-		log.FromContext(r.Context()).Debug("rollup",
-			zap.String("runtime", rollupTime.String()),
-			zap.Duration("runtime_ns", rollupTime),
-		)
-		log.FromContext(r.Context()).Debug("pickle",
-			zap.String("runtime", pickleTime.String()),
-			zap.Duration("runtime_ns", pickleTime),
-		)
-	}()
-
-	if len(points) == 0 {
-		w.Write(pickle.EmptyList)
-		return
-	}
-
-	writer := bufio.NewWriterSize(w, 1024*1024)
-	p := pickle.NewWriter(writer)
-	defer writer.Flush()
-
-	p.List()
+type pickleWriter struct {
+	p *pickle.Writer
+}
 
-	writeMetric := func(name string, pathExpression string, points []point.Point) {
-		rollupStart := time.Now()
-		points, step := rollupObj.RollupMetric(data.Points.MetricName(points[0].MetricID), from, points)
-		rollupTime += time.Since(rollupStart)
+func newPickleWriter(w io.Writer) *pickleWriter {
+	return &pickleWriter{p: pickle.NewWriter(w)}
+}
 
-		pickleStart := time.Now()
-		p.Dict()
+func (pw *pickleWriter) contentType() string {
+	return "application/pickle"
+}
 
-		p.String("name")
-		p.String(name)
-		p.SetItem()
+func (pw *pickleWriter) begin() {
+	pw.p.List()
+}
 
-		p.String("pathExpression")
-		p.String(pathExpression)
-		p.SetItem()
+func (pw *pickleWriter) write(s *series) {
+	p := pw.p
+	step := s.step
 
-		p.String("step")
-		p.Uint32(step)
-		p.SetItem()
+	p.Dict()
 
-		start := from - (from % step)
-		if start < from {
-			start += step
-		}
-		end := until - (until % step)
-		last := start - step
+	p.String("name")
+	p.String(s.name)
+	p.SetItem()
 
-		p.String("values")
-		p.List()
-		for _, point := range points {
-			if point.Time < start || point.Time > end {
-				continue
-			}
+	p.String("pathExpression")
+	p.String(s.pathExpression)
+	p.SetItem()
 
-			if point.Time > last+step {
-				p.AppendNulls(int(((point.Time - last) / step) - 1))
-			}
+	p.String("step")
+	p.Uint32(step)
+	p.SetItem()
 
-			p.AppendFloat64(point.Value)
+	start, end := s.bounds()
+	last := start - step
 
-			last = point.Time
+	p.String("values")
+	p.List()
+	for _, point := range s.points {
+		if point.Time < start || point.Time > end {
+			continue
 		}
 
-		if end > last {
-			p.AppendNulls(int((end - last) / step))
+		if point.Time > last+step {
+			p.AppendNulls(int(((point.Time - last) / step) - 1))
 		}
-		p.SetItem()
-
-		p.String("start")
-		p.Uint32(uint32(start))
-		p.SetItem()
 
-		p.String("end")
-		p.Uint32(uint32(end))
-		p.SetItem()
+		p.AppendFloat64(point.Value)
 
-		p.Append()
-		pickleTime += time.Since(pickleStart)
+		last = point.Time
 	}
 
-	// group by Metric
-	var i, n, k int
-	// i - current position of iterator
-	// n - position of the first record with current metric
-	l := len(points)
-
-	for i = 1; i < l; i++ {
-		if points[i].MetricID != points[n].MetricID {
-			a := data.Aliases[data.Points.MetricName(points[n].MetricID)]
-			for k = 0; k < len(a); k += 2 {
-				writeMetric(a[k], a[k+1], points[n:i])
-			}
-			n = i
-			continue
-		}
+	if end > last {
+		p.AppendNulls(int((end - last) / step))
 	}
+	p.SetItem()
 
-	a := data.Aliases[data.Points.MetricName(points[n].MetricID)]
-	for k = 0; k < len(a); k += 2 {
-		writeMetric(a[k], a[k+1], points[n:i])
-	}
+	p.String("start")
+	p.Uint32(uint32(start))
+	p.SetItem()
+
+	p.String("end")
+	p.Uint32(uint32(end))
+	p.SetItem()
+
+	p.Append()
+}
 
-	p.Stop()
+func (pw *pickleWriter) end() {
+	pw.p.Stop()
 }
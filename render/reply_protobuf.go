@@ -3,134 +3,107 @@ package render
 import (
 	"bufio"
 	"bytes"
-	"net/http"
-
-	"github.com/lomik/graphite-clickhouse/helper/point"
-	"github.com/lomik/graphite-clickhouse/helper/rollup"
 )
 
-func (h *Handler) ReplyProtobuf(w http.ResponseWriter, r *http.Request, data *Data, from, until uint32, prefix string, rollupObj *rollup.Rollup) {
-	points := data.Points.List()
-
-	if len(points) == 0 {
-		return
-	}
-
-	// var multiResponse carbonzipperpb.MultiFetchResponse
-	writer := bufio.NewWriterSize(w, 1024*1024)
-	defer writer.Flush()
-
-	mb := new(bytes.Buffer)
-
-	writeMetric := func(name string, points []point.Point) {
-		points, step := rollupObj.RollupMetric(data.Points.MetricName(points[0].MetricID), from, points)
+// protobufWriter writes carbonzipperpb.MultiFetchResponse
+type protobufWriter struct {
+	w  *bufio.Writer
+	mb bytes.Buffer
+}
 
-		start := from - (from % step)
-		if start < from {
-			start += step
-		}
-		stop := until - (until % step)
-		count := ((stop - start) / step) + 1
+func (pw *protobufWriter) contentType() string {
+	return "application/x-protobuf"
+}
 
-		mb.Reset()
+func (pw *protobufWriter) begin() {}
 
-		// name
-		VarintWrite(mb, (1<<3)+2) // tag
-		VarintWrite(mb, uint64(len(name)))
-		mb.WriteString(name)
+func (pw *protobufWriter) write(s *series) {
+	writer := pw.w
+	mb := &pw.mb
+	step := s.step
 
-		// start
-		VarintWrite(mb, 2<<3)
-		VarintWrite(mb, uint64(start))
+	start, stop := s.bounds()
+	count := s.count()
 
-		// stop
-		VarintWrite(mb, 3<<3)
-		VarintWrite(mb, uint64(stop))
+	mb.Reset()
 
-		// step
-		VarintWrite(mb, 4<<3)
-		VarintWrite(mb, uint64(step))
+	// name
+	VarintWrite(mb, (1<<3)+2) // tag
+	VarintWrite(mb, uint64(len(s.name)))
+	mb.WriteString(s.name)
 
-		// start write to output
+	// start
+	VarintWrite(mb, 2<<3)
+	VarintWrite(mb, uint64(start))
 
-		// repeated FetchResponse metrics = 1;
-		// write tag and len
-		VarintWrite(writer, (1<<3)+2)
-		VarintWrite(writer,
-			uint64(mb.Len())+
-				2+ // tags of <repeated double values = 5;> and <repeated bool isAbsent = 6;>
-				VarintLen(uint64(8*count))+ // len of packed <repeated double values>
-				VarintLen(uint64(count))+ // len of packed <repeated bool isAbsent>
-				uint64(9*count), // packed <repeated double values> and <repeated bool isAbsent>
-		)
+	// stop
+	VarintWrite(mb, 3<<3)
+	VarintWrite(mb, uint64(stop))
 
-		writer.Write(mb.Bytes())
+	// step
+	VarintWrite(mb, 4<<3)
+	VarintWrite(mb, uint64(step))
 
-		// Write values
-		VarintWrite(writer, (5<<3)+2)
-		VarintWrite(writer, uint64(8*count))
+	// start write to output
 
-		last := start - step
-		for _, point := range points {
-			if point.Time < start || point.Time > stop {
-				continue
-			}
+	// repeated FetchResponse metrics = 1;
+	// write tag and len
+	VarintWrite(writer, (1<<3)+2)
+	VarintWrite(writer,
+		uint64(mb.Len())+
+			2+ // tags of <repeated double values = 5;> and <repeated bool isAbsent = 6;>
+			VarintLen(uint64(8*count))+ // len of packed <repeated double values>
+			VarintLen(uint64(count))+ // len of packed <repeated bool isAbsent>
+			uint64(9*count), // packed <repeated double values> and <repeated bool isAbsent>
+	)
 
-			if point.Time > last+step {
-				ProtobufWriteDoubleN(writer, 0, int(((point.Time-last)/step)-1))
-			}
+	writer.Write(mb.Bytes())
 
-			ProtobufWriteDouble(writer, point.Value)
+	// Write values
+	VarintWrite(writer, (5<<3)+2)
+	VarintWrite(writer, uint64(8*count))
 
-			last = point.Time
+	last := start - step
+	for _, point := range s.points {
+		if point.Time < start || point.Time > stop {
+			continue
 		}
 
-		if stop > last {
-			ProtobufWriteDoubleN(writer, 0, int((stop-last)/step))
+		if point.Time > last+step {
+			ProtobufWriteDoubleN(writer, 0, int(((point.Time-last)/step)-1))
 		}
 
-		// Write isAbsent
-		VarintWrite(writer, (6<<3)+2)
-		VarintWrite(writer, uint64(count))
+		ProtobufWriteDouble(writer, point.Value)
 
-		last = start - step
-		for _, point := range points {
-			if point.Time < start || point.Time > stop {
-				continue
-			}
+		last = point.Time
+	}
 
-			if point.Time > last+step {
-				WriteByteN(writer, '\x01', int(((point.Time-last)/step)-1))
-			}
+	if stop > last {
+		ProtobufWriteDoubleN(writer, 0, int((stop-last)/step))
+	}
 
-			writer.WriteByte('\x00')
+	// Write isAbsent
+	VarintWrite(writer, (6<<3)+2)
+	VarintWrite(writer, uint64(count))
 
-			last = point.Time
+	last = start - step
+	for _, point := range s.points {
+		if point.Time < start || point.Time > stop {
+			continue
 		}
 
-		if stop > last {
-			WriteByteN(writer, '\x01', int((stop-last)/step))
+		if point.Time > last+step {
+			WriteByteN(writer, '\x01', int(((point.Time-last)/step)-1))
 		}
-	}
 
-	// group by Metric
-	var i, n, k int
-	// i - current position of iterator
-	// n - position of the first record with current metric
-	l := len(points)
-
-	for i = 1; i < l; i++ {
-		if points[i].MetricID != points[n].MetricID {
-			a := data.Aliases[data.Points.MetricName(points[n].MetricID)]
-			for k = 0; k < len(a); k += 2 {
-				writeMetric(a[k], points[n:i])
-			}
-			n = i
-			continue
-		}
+		writer.WriteByte('\x00')
+
+		last = point.Time
 	}
-	a := data.Aliases[data.Points.MetricName(points[n].MetricID)]
-	for k = 0; k < len(a); k += 2 {
-		writeMetric(a[k], points[n:i])
+
+	if stop > last {
+		WriteByteN(writer, '\x01', int((stop-last)/step))
 	}
 }
+
+func (pw *protobufWriter) end() {}
@@ -0,0 +1,80 @@
+package render
+
+import (
+	"bufio"
+	"math"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/lomik/graphite-clickhouse/carbonapi_v3_pb"
+)
+
+// seriesTags returns graphite tags of series. "name" for plain metrics, "name" and all tags for "name;tag=value;..."
+func seriesTags(name string) map[string]string {
+	a := strings.Split(name, ";")
+	tags := make(map[string]string, len(a))
+	tags["name"] = a[0]
+
+	for i := 1; i < len(a); i++ {
+		kv := strings.SplitN(a[i], "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return tags
+}
+
+// protobufV3Writer writes carbonapi_v3_pb.MultiFetchResponse. Every FetchResponse is marshaled separately
+// and written as repeated field <metrics = 1>
+type protobufV3Writer struct {
+	w *bufio.Writer
+}
+
+func (pw *protobufV3Writer) contentType() string {
+	return "application/x-protobuf"
+}
+
+func (pw *protobufV3Writer) begin() {}
+
+func (pw *protobufV3Writer) write(s *series) {
+	start, stop := s.bounds()
+	values := make([]float64, s.count())
+	for i := 0; i < len(values); i++ {
+		values[i] = math.NaN()
+	}
+
+	for _, point := range s.points {
+		if point.Time < start || point.Time > stop {
+			continue
+		}
+		values[(point.Time-start)/s.step] = point.Value
+	}
+
+	body, err := proto.Marshal(&carbonapi_v3_pb.FetchResponse{
+		Name:              s.name,
+		PathExpression:    s.pathExpression,
+		ConsolidationFunc: consolidateName(s.aggr),
+		StartTime:         int64(start),
+		StopTime:          int64(stop),
+		StepTime:          int64(s.step),
+		XFilesFactor:      float32(s.xFilesFactor),
+		Values:            values,
+		RequestStartTime:  int64(s.from),
+		RequestStopTime:   int64(s.until),
+		Tags:              seriesTags(s.name),
+	})
+	if err != nil {
+		// unreachable for valid message
+		return
+	}
+
+	// repeated FetchResponse metrics = 1;
+	VarintWrite(pw.w, (1<<3)+2)
+	VarintWrite(pw.w, uint64(len(body)))
+	pw.w.Write(body)
+}
+
+func (pw *protobufV3Writer) end() {}
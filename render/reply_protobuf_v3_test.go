@@ -0,0 +1,194 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/carbonapi_v3_pb"
+	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+func TestParseRequestProtobufV3(t *testing.T) {
+	assert := assert.New(t)
+
+	body, err := proto.Marshal(&carbonapi_v3_pb.MultiFetchRequest{
+		Metrics: []*carbonapi_v3_pb.FetchRequest{
+			{Name: "a.*", PathExpression: "a.*", StartTime: 1000, StopTime: 2000},
+			{Name: "b.*", PathExpression: "b.*", StartTime: 500, StopTime: 2000},
+			{Name: "c.*", PathExpression: "c.*", StartTime: 1000, StopTime: 2000},
+			{Name: "d.*", PathExpression: "d.*", StartTime: 1000, StopTime: 2000, MaxDataPoints: 100},
+		},
+	})
+	assert.NoError(err)
+
+	r := httptest.NewRequest("POST", "/render/?format=carbonapi_v3_pb", bytes.NewReader(body))
+	r.ParseMultipartForm(1024 * 1024)
+
+	m, err := ParseRequest(r)
+	assert.NoError(err)
+
+	assert.Equal(MultiFetchRequest{
+		{TimeFrame: TimeFrame{From: 1000, Until: 2000}, Targets: []Target{{Name: "a.*"}, {Name: "c.*"}}},
+		{TimeFrame: TimeFrame{From: 500, Until: 2000}, Targets: []Target{{Name: "b.*"}}},
+		{TimeFrame: TimeFrame{From: 1000, Until: 2000, MaxDataPoints: 100}, Targets: []Target{{Name: "d.*"}}},
+	}, m)
+}
+
+func TestReplyProtobufV3(t *testing.T) {
+	assert := assert.New(t)
+
+	rollupObj, err := rollup.ParseXML([]byte(`
+<graphite_rollup>
+ 	<default>
+ 		<function>max</function>
+ 		<retention>
+ 			<age>0</age>
+ 			<precision>60</precision>
+ 		</retention>
+ 	</default>
+</graphite_rollup>
+`))
+	assert.NoError(err)
+
+	from := uint32(1520056680)
+	until := from + 120
+
+	points := []testPoint{
+		{"cpu;host=a", 1, from + 1, from + 1},
+		{"cpu;host=a", 3, from + 2, from + 2},
+		{"cpu;host=a", 4.5, from + 120, from + 120},
+	}
+
+	data := &Data{
+		Aliases: map[string][]string{"cpu;host=a": {"cpu;host=a", "seriesByTag('name=cpu')"}},
+		From:    from,
+		Until:   until,
+		Rollup:  rollupObj.Rules(),
+	}
+
+	w := testReply("carbonapi_v3_pb", data, points)
+
+	var response carbonapi_v3_pb.MultiFetchResponse
+	assert.NoError(proto.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(response.Metrics, 1)
+
+	m := response.Metrics[0]
+	assert.Equal("cpu;host=a", m.Name)
+	assert.Equal("seriesByTag('name=cpu')", m.PathExpression)
+	assert.Equal("max", m.ConsolidationFunc)
+	assert.Equal(int64(from), m.StartTime)
+	assert.Equal(int64(until), m.StopTime)
+	assert.Equal(int64(60), m.StepTime)
+	assert.Equal(map[string]string{"name": "cpu", "host": "a"}, m.Tags)
+	assert.Len(m.Values, 3)
+	assert.Equal(3.0, m.Values[0])
+	assert.True(math.IsNaN(m.Values[1]))
+	assert.Equal(4.5, m.Values[2])
+}
+
+func TestReplyProtobufV3ShortRange(t *testing.T) {
+	assert := assert.New(t)
+
+	var body bytes.Buffer
+	w := bufio.NewWriter(&body)
+
+	pw := &protobufV3Writer{w: w}
+	pw.write(&series{
+		name:   "a.b",
+		points: []point.Point{{MetricID: 1, Time: 60, Value: 1}},
+		step:   60,
+		from:   100,
+		until:  110,
+		aggr:   rollup.GetAggr("max"),
+	})
+	w.Flush()
+
+	var response carbonapi_v3_pb.MultiFetchResponse
+	assert.NoError(proto.Unmarshal(body.Bytes(), &response))
+	if assert.Len(response.Metrics, 1) {
+		assert.Len(response.Metrics[0].Values, 0)
+	}
+}
+
+// TestReplyProtobufV3WireFormat checks field numbers and wire types of reply against
+// FetchResponse of github.com/go-graphite/protocol carbonapi_v3_pb
+func TestReplyProtobufV3WireFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	upstream := map[uint64]uint64{
+		1:  proto.WireBytes,   // name
+		2:  proto.WireBytes,   // pathExpression
+		3:  proto.WireBytes,   // consolidationFunc
+		4:  proto.WireVarint,  // startTime
+		5:  proto.WireVarint,  // stopTime
+		6:  proto.WireVarint,  // stepTime
+		7:  proto.WireFixed32, // xFilesFactor
+		8:  proto.WireVarint,  // highPrecisionTimestamps
+		9:  proto.WireBytes,   // values, packed
+		10: proto.WireBytes,   // tags, map entry
+		11: proto.WireBytes,   // appliedFunctions
+		12: proto.WireVarint,  // requestStartTime
+		13: proto.WireVarint,  // requestStopTime
+	}
+
+	var body bytes.Buffer
+	w := bufio.NewWriter(&body)
+
+	pw := &protobufV3Writer{w: w}
+	pw.write(&series{
+		name:           "cpu;host=a",
+		pathExpression: "seriesByTag('name=cpu')",
+		points:         []point.Point{{MetricID: 1, Time: 120, Value: 1}},
+		step:           60,
+		from:           100,
+		until:          200,
+		xFilesFactor:   0.5,
+		aggr:           rollup.GetAggr("max"),
+	})
+	w.Flush()
+
+	buf := body.Bytes()
+	key, n := proto.DecodeVarint(buf)
+	assert.Equal(uint64(1<<3|proto.WireBytes), key)
+	size, m := proto.DecodeVarint(buf[n:])
+	msg := buf[n+m:]
+	if !assert.Equal(int(size), len(msg)) {
+		return
+	}
+
+	seen := make(map[uint64]bool)
+	for len(msg) > 0 {
+		key, n := proto.DecodeVarint(msg)
+		field, wire := key>>3, key&7
+		assert.Equal(upstream[field], wire, "wire type of field %d", field)
+		seen[field] = true
+		msg = msg[n:]
+
+		switch wire {
+		case proto.WireVarint:
+			_, n = proto.DecodeVarint(msg)
+		case proto.WireFixed32:
+			n = 4
+		case proto.WireBytes:
+			size, m := proto.DecodeVarint(msg)
+			n = m + int(size)
+		default:
+			t.Fatalf("unexpected wire type %d of field %d", wire, field)
+		}
+		if n == 0 || n > len(msg) {
+			t.Fatalf("truncated field %d", field)
+		}
+		msg = msg[n:]
+	}
+
+	for _, field := range []uint64{1, 2, 3, 4, 5, 6, 7, 9, 10, 12, 13} {
+		assert.True(seen[field], "field %d is missing", field)
+	}
+}
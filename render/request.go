@@ -0,0 +1,178 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gogo/protobuf/proto"
+
+	"github.com/lomik/graphite-clickhouse/carbonapi_v3_pb"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+var errBadRequest = errors.New("Bad request")
+
+// TimeFrame is a requested time range with max count of points per series
+type TimeFrame struct {
+	From          int64
+	Until         int64
+	MaxDataPoints int64 // 0 - unlimited
+}
+
+// Target is a requested series expression
+type Target struct {
+	Name          string
+	ConsolidateBy string // function for rollup and consolidation to maxDataPoints. Empty - rollup function of series
+}
+
+// FetchRequest is a list of targets with the same time frame
+type FetchRequest struct {
+	TimeFrame
+	Targets []Target
+}
+
+// MultiFetchRequest is a list of FetchRequest with unique time frames in order of appearance
+type MultiFetchRequest []*FetchRequest
+
+// Add appends target to request with same time frame or creates new one
+func (m *MultiFetchRequest) Add(tf TimeFrame, target Target) {
+	for _, f := range *m {
+		if f.TimeFrame == tf {
+			f.Targets = append(f.Targets, target)
+			return
+		}
+	}
+
+	*m = append(*m, &FetchRequest{
+		TimeFrame: tf,
+		Targets:   []Target{target},
+	})
+}
+
+// consolidateAggr returns aggregate function for graphite consolidateBy() function name
+func consolidateAggr(name string) *rollup.Aggr {
+	switch name {
+	case "average":
+		name = "avg"
+	case "first":
+		name = "any"
+	case "last":
+		name = "anyLast"
+	}
+
+	return rollup.GetAggr(name)
+}
+
+// consolidateName returns graphite consolidateBy() function name for aggregate function
+func consolidateName(aggr *rollup.Aggr) string {
+	switch aggr.Name() {
+	case "avg":
+		return "average"
+	case "any":
+		return "first"
+	case "anyLast":
+		return "last"
+	}
+
+	return aggr.Name()
+}
+
+// ParseRequest reads targets and time frames from graphite-web form values
+// or from carbonapi_v3_pb MultiFetchRequest in POST body.
+// Consolidation function is set by consolidateBy form value or by consolidateBy filter function of carbonapi_v3_pb request
+func ParseRequest(r *http.Request) (MultiFetchRequest, error) {
+	if r.FormValue("format") == "carbonapi_v3_pb" {
+		return parseProtobufRequest(r)
+	}
+
+	return parseFormRequest(r)
+}
+
+func parseFormRequest(r *http.Request) (MultiFetchRequest, error) {
+	fromTimestamp, err := strconv.ParseInt(r.FormValue("from"), 10, 32)
+	if err != nil {
+		return nil, errBadRequest
+	}
+
+	untilTimestamp, err := strconv.ParseInt(r.FormValue("until"), 10, 32)
+	if err != nil {
+		return nil, errBadRequest
+	}
+
+	var maxDataPoints int64
+	if r.FormValue("maxDataPoints") != "" {
+		maxDataPoints, err = strconv.ParseInt(r.FormValue("maxDataPoints"), 10, 64)
+		if err != nil || maxDataPoints < 0 {
+			return nil, errBadRequest
+		}
+	}
+
+	consolidateBy := r.FormValue("consolidateBy")
+	if consolidateBy != "" && consolidateAggr(consolidateBy) == nil {
+		return nil, fmt.Errorf("unknown consolidateBy function %#v", consolidateBy)
+	}
+
+	tf := TimeFrame{
+		From:          fromTimestamp,
+		Until:         untilTimestamp,
+		MaxDataPoints: maxDataPoints,
+	}
+
+	m := make(MultiFetchRequest, 0, 1)
+	for _, target := range r.Form["target"] {
+		if len(target) == 0 {
+			continue
+		}
+		m.Add(tf, Target{Name: target, ConsolidateBy: consolidateBy})
+	}
+
+	return m, nil
+}
+
+func parseProtobufRequest(r *http.Request) (MultiFetchRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var pv3Request carbonapi_v3_pb.MultiFetchRequest
+	if err := proto.Unmarshal(body, &pv3Request); err != nil {
+		return nil, errBadRequest
+	}
+
+	m := make(MultiFetchRequest, 0, len(pv3Request.Metrics))
+	for _, t := range pv3Request.Metrics {
+		if t == nil || len(t.PathExpression) == 0 && len(t.Name) == 0 {
+			continue
+		}
+
+		target := t.PathExpression
+		if target == "" {
+			target = t.Name
+		}
+
+		tf := TimeFrame{
+			From:          t.StartTime,
+			Until:         t.StopTime,
+			MaxDataPoints: t.MaxDataPoints,
+		}
+
+		var consolidateBy string
+		for _, f := range t.FilterFunctions {
+			if f == nil || f.Name != "consolidateBy" || len(f.Arguments) == 0 {
+				continue
+			}
+			consolidateBy = f.Arguments[0]
+			if consolidateAggr(consolidateBy) == nil {
+				return nil, fmt.Errorf("unknown consolidateBy function %#v", consolidateBy)
+			}
+		}
+
+		m.Add(tf, Target{Name: target, ConsolidateBy: consolidateBy})
+	}
+
+	return m, nil
+}
@@ -0,0 +1,41 @@
+package render
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lomik/graphite-clickhouse/carbonapi_v3_pb"
+)
+
+func TestParseProtobufRequestConsolidateBy(t *testing.T) {
+	assert := assert.New(t)
+
+	request := func(consolidateBy string) (MultiFetchRequest, error) {
+		body, err := proto.Marshal(&carbonapi_v3_pb.MultiFetchRequest{
+			Metrics: []*carbonapi_v3_pb.FetchRequest{{
+				PathExpression: "a.*",
+				StartTime:      1520056680,
+				StopTime:       1520056739,
+				FilterFunctions: []*carbonapi_v3_pb.FilteringFunction{
+					{Name: "consolidateBy", Arguments: []string{consolidateBy}},
+				},
+			}},
+		})
+		assert.NoError(err)
+
+		r := httptest.NewRequest("POST", "/render/?format=carbonapi_v3_pb", bytes.NewReader(body))
+		return ParseRequest(r)
+	}
+
+	m, err := request("max")
+	assert.NoError(err)
+	assert.Len(m, 1)
+	assert.Equal([]Target{{Name: "a.*", ConsolidateBy: "max"}}, m[0].Targets)
+
+	_, err = request("unknown")
+	assert.Error(err)
+}
@@ -0,0 +1,56 @@
+package render
+
+import (
+	"github.com/lomik/graphite-clickhouse/helper/point"
+	"github.com/lomik/graphite-clickhouse/helper/rollup"
+)
+
+type seriesKey struct {
+	name           string
+	pathExpression string
+}
+
+// stitchSeries merges parts of one series read from several data tables in order of time.
+// Parts are rolled up to the coarsest step, points of the next part in already covered intervals are skipped.
+// Result is consolidated to maxDataPoints in requested time range [from, until]
+func stitchSeries(parts []*series, from, until uint32, maxDataPoints int64) *series {
+	last := parts[len(parts)-1]
+
+	var step uint32
+	for _, p := range parts {
+		if p.step > step {
+			step = p.step
+		}
+	}
+
+	points := make([]point.Point, 0)
+	for _, p := range parts {
+		pp := p.points
+		if p.step != step {
+			pp = rollup.RollupPoints(pp, p.step, step, p.aggr, p.xFilesFactor)
+		}
+
+		for _, pt := range pp {
+			if len(points) > 0 && pt.Time <= points[len(points)-1].Time {
+				continue
+			}
+			points = append(points, pt)
+		}
+	}
+
+	newStep := consolidateStep(step, from, until, maxDataPoints)
+	if newStep != step {
+		points = rollup.RollupPoints(points, step, newStep, last.aggr, last.xFilesFactor)
+	}
+
+	return &series{
+		name:           last.name,
+		pathExpression: last.pathExpression,
+		points:         points,
+		step:           newStep,
+		from:           from,
+		until:          until,
+		aggr:           last.aggr,
+		xFilesFactor:   last.xFilesFactor,
+	}
+}
@@ -0,0 +1,97 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/lomik/graphite-clickhouse/helper/point"
+)
+
+// DataStream reads RowBinary body sorted by Path and calls callback with points of each metric as soon as they are read.
+// Points slice is reused after callback returns
+func DataStream(bodyReader io.Reader, isReverse bool, callback func(metricName string, points []point.Point) error) error {
+	name := make([]byte, 0, 256)
+	points := make([]point.Point, 0)
+
+	flush := func() error {
+		if len(points) == 0 {
+			return nil
+		}
+
+		metricName := string(name)
+		if isReverse {
+			metricName = reversePath(metricName)
+		}
+
+		err := callback(metricName, points)
+		points = points[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(bodyReader)
+	scanner.Buffer(make([]byte, 1048576), 1048576)
+	scanner.Split(DataSplitFunc)
+
+	for scanner.Scan() {
+		row := scanner.Bytes()
+
+		namelen, readBytes, err := ReadUvarint(row)
+		if err != nil {
+			return errClickHouseResponse
+		}
+		row = row[int(readBytes):]
+
+		newName := row[:int(namelen)]
+		row = row[int(namelen):]
+
+		if !bytes.Equal(newName, name) {
+			if err := flush(); err != nil {
+				return err
+			}
+			name = append(name[:0], newName...)
+		}
+
+		points = append(points, point.Point{
+			MetricID:  1,
+			Time:      binary.LittleEndian.Uint32(row[:4]),
+			Value:     math.Float64frombits(binary.LittleEndian.Uint64(row[4:12])),
+			Timestamp: binary.LittleEndian.Uint32(row[12:16]),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// splitByMetric returns points of each metric. All points have MetricID = 1 as points from DataStream
+func splitByMetric(pp *point.Points) map[string][]point.Point {
+	result := make(map[string][]point.Point)
+	if pp == nil {
+		return result
+	}
+
+	for _, p := range pp.List() {
+		name := pp.MetricName(p.MetricID)
+		p.MetricID = 1
+		result[name] = append(result[name], p)
+	}
+
+	return result
+}
+
+// mergePoints appends extra points to points of metric and removes duplicates
+func mergePoints(points []point.Point, extra []point.Point) []point.Point {
+	if len(extra) > 0 {
+		points = append(points, extra...)
+		sort.Slice(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+	}
+
+	return point.Uniq(points)
+}